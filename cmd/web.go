@@ -1,21 +1,47 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/labdao/plex/gateway"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/spf13/cobra"
 )
 
+var (
+	configFile  string
+	printConfig bool
+)
+
 var webCmd = &cobra.Command{
 	Use:   "web",
 	Short: "Runs the Gateway web app",
 	Long:  `Runs the Gateway web app`,
 	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(configFile)
+		if err == nil && printConfig {
+			err = cfg.Print(os.Stdout)
+		}
+		if err == nil {
+			err = cfg.ValidateWeb()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		if printConfig {
+			return
+		}
+
 		dry := true
 		upgradePlexVersion(dry)
-		gateway.ServeWebApp()
+		gateway.ServeWebApp(cfg)
 	},
 }
 
 func init() {
+	webCmd.Flags().StringVar(&configFile, "config", "", "JSON config file, defaults to $"+config.FileEnv)
+	webCmd.Flags().BoolVar(&printConfig, "print-config", false, "Print the effective config with secrets masked, validate it and exit")
 	rootCmd.AddCommand(webCmd)
 }
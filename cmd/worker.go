@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/labdao/plex/gateway"
+	"github.com/labdao/plex/gateway/config"
+	"github.com/spf13/cobra"
+)
+
+var workerConfigFile string
+
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Runs the Gateway job queue workers",
+	Long:  `Runs the Gateway job queue workers and the running job monitor without the web app`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(workerConfigFile)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		gateway.RunWorkers(cfg)
+	},
+}
+
+func init() {
+	workerCmd.Flags().StringVar(&workerConfigFile, "config", "", "JSON config file, defaults to $"+config.FileEnv)
+	rootCmd.AddCommand(workerCmd)
+}
@@ -1,22 +1,31 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/labdao/plex/gateway/config"
+	"github.com/labdao/plex/gateway/handlers"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/server"
 	"github.com/labdao/plex/gateway/utils"
 
+	"github.com/labdao/plex/internal/logging"
+	"github.com/labdao/plex/internal/ray"
 	"github.com/labdao/plex/internal/s3"
 
 	"github.com/rs/cors"
@@ -26,57 +35,171 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-func ServeWebApp() {
-	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold: time.Second,  // Slow SQL threshold
-			LogLevel:      logger.Error, // Log level
-			Colorful:      true,         // Enable color
-		},
-	)
+// fatal logs an error the gateway cannot start without and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// configure hands the config to the packages that used to read the
+// environment themselves.
+func configure(cfg *config.Config) {
+	utils.Configure(cfg)
+	ray.Configure(ray.Settings{
+		APIHost:         cfg.Ray.APIHost,
+		JobAPIHost:      cfg.Ray.JobAPIHost,
+		CallbackBaseURL: cfg.Ray.CallbackBaseURL,
+		CallbackSecret:  cfg.Ray.CallbackSecret,
+	})
+	s3.Configure(s3.Settings{
+		Region:          cfg.Bucket.Region,
+		Endpoint:        cfg.Bucket.Endpoint,
+		UseSSL:          cfg.Bucket.UseSSL,
+		AccessKeyID:     cfg.Bucket.AccessKeyID,
+		SecretAccessKey: cfg.Bucket.SecretAccessKey,
+	})
+}
+
+// waitForSignal blocks until ctx, from signal.NotifyContext, is done and
+// returns the context that bounds the shutdown, in which in-flight requests
+// and jobs get to finish.
+func waitForSignal(ctx context.Context, stop context.CancelFunc, cfg *config.Config) (context.Context, context.CancelFunc) {
+	<-ctx.Done()
+	// A second signal kills the process right away
+	stop()
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeoutSeconds) * time.Second
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	return context.WithTimeout(context.Background(), shutdownTimeout)
+}
+
+// closeResources closes the database pool and flushes the remaining spans
+// once nothing uses them anymore.
+func closeResources(db *gorm.DB, shutdownTracing func(context.Context) error) {
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	slog.Info("Shutdown complete")
+}
 
-	endpoint := os.Getenv("BUCKET_ENDPOINT")
-	bucketName := os.Getenv("BUCKET_NAME")
+// ServeWebApp runs the web app with a config that passed ValidateWeb.
+func ServeWebApp(cfg *config.Config) {
+	logging.Setup()
+	shutdownTracing := setupTracing("plex-gateway")
+	configure(cfg)
 
-	endpoint = strings.TrimPrefix(endpoint, "http://")
-	endpoint = strings.TrimPrefix(endpoint, "https://")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bucketName := cfg.Bucket.Name
 
 	s3Client, err := s3.NewS3Client()
 	if err != nil {
-		log.Fatalf("failed to create s3 client: %v", err)
+		fatal("Failed to create S3 client", "error", err)
 	} else {
-		fmt.Println("S3 client created successfully")
-
+		slog.Info("S3 client created successfully")
 	}
 
 	exists, err := s3Client.BucketExists(bucketName)
 	if err != nil {
-		log.Fatalf("Failed to check if bucket exists: %v", err)
+		fatal("Failed to check if bucket exists", "bucket", bucketName, "error", err)
 	}
 	if !exists {
 		err := s3Client.CreateBucket(bucketName)
 		if err != nil {
-			log.Fatalf("Failed to create bucket: %v", err)
+			fatal("Failed to create bucket", "bucket", bucketName, "error", err)
 		}
-		fmt.Println("Bucket created successfully")
+		slog.Info("Bucket created successfully", "bucket", bucketName)
 	}
 
-	// Setup database connection
-	// Get environment variables
-	host := os.Getenv("POSTGRES_HOST")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbname := os.Getenv("POSTGRES_DB")
-
-	privyAppId := os.Getenv("NEXT_PUBLIC_PRIVY_APP_ID")
-	publicKey := os.Getenv("PRIVY_PUBLIC_KEY")
-
 	privyVerificationKey := fmt.Sprintf(`-----BEGIN PUBLIC KEY-----
 %s
------END PUBLIC KEY-----`, publicKey)
+-----END PUBLIC KEY-----`, cfg.Auth.PrivyPublicKey)
+
+	middleware.SetupConfig(cfg.Auth.PrivyAppID, privyVerificationKey)
+
+	db := SetupDatabase(cfg.Database)
+
+	// Set up CORS
+	corsMiddleware := cors.New(cors.Options{
+		AllowedOrigins:   []string{cfg.Server.FrontendURL, "http://localhost:3000", "http://frontend:3000"},
+		AllowCredentials: true,
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Requested-With", "X-Request-ID"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+	})
+
+	mux := server.NewServer(cfg, db, s3Client)
+
+	// Queue workers can run in separate `plex worker` processes instead
+	var stopBackgroundJobs func(context.Context)
+	if cfg.Queue.RunWorkers {
+		stopBackgroundJobs = startBackgroundJobs(ctx, cfg, db)
+	}
+
+	// Start the server with CORS middleware
+	// Event and log streams end when the shutdown starts, other requests
+	// get to finish
+	shuttingDown := make(chan struct{})
+	srv := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: corsMiddleware.Handler(mux),
+		BaseContext: func(net.Listener) context.Context {
+			return handlers.WithShutdown(context.Background(), shuttingDown)
+		},
+	}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+	go func() {
+		slog.Info("Server started", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server stopped", "error", err)
+		}
+	}()
+	metricsServer := serveMetrics(cfg.Server.MetricsAddr, db)
 
-	middleware.SetupConfig(privyAppId, privyVerificationKey)
+	shutdownCtx, cancel := waitForSignal(ctx, stop, cfg)
+	defer cancel()
+
+	// Stop accepting connections and let in-flight requests, like uploads
+	// and experiment submissions, finish. The workers drain meanwhile.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Closing connections of requests that did not finish in time", "error", err)
+		srv.Close()
+	}
+	if stopBackgroundJobs != nil {
+		stopBackgroundJobs(shutdownCtx)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	closeResources(db, shutdownTracing)
+}
+
+// SetupDatabase runs the migrations and opens the connection pool shared by
+// the web app and the workers.
+func SetupDatabase(cfg config.Database) *gorm.DB {
+	newLogger := logger.New(
+		slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn), // io writer
+		logger.Config{
+			SlowThreshold: time.Second,  // Slow SQL threshold
+			LogLevel:      logger.Error, // Log level
+			Colorful:      false,
+		},
+	)
+
+	host := cfg.Host
+	user := cfg.User
+	password := cfg.Password
+	dbname := cfg.Name
 
 	// DSN for gorm.Open
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s", host, user, password, dbname)
@@ -91,10 +214,10 @@ func ServeWebApp() {
 		migrateDSN,
 	)
 	if err != nil {
-		log.Fatalf("Could not create migration: %v", err)
+		fatal("Could not create migration", "error", err)
 	}
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Fatalf("An error occurred while migrating the database: %v", err)
+		fatal("An error occurred while migrating the database", "error", err)
 	}
 
 	// If needed use log level debug or info. Default set to silent to avoid noisy logs
@@ -115,7 +238,7 @@ func ServeWebApp() {
 	sqlDB.SetConnMaxLifetime(time.Hour) // Connections are recycled every hour
 
 	// Migrate the schema
-	if err := db.AutoMigrate(&models.File{}, &models.User{}, &models.Model{}, &models.Job{}, &models.Tag{}, &models.Transaction{}, &models.InferenceEvent{}, &models.FileEvent{}, &models.UserEvent{}, &models.Organization{}, &models.Design{}); err != nil {
+	if err := db.AutoMigrate(&models.File{}, &models.User{}, &models.Model{}, &models.Job{}, &models.Tag{}, &models.Transaction{}, &models.InferenceEvent{}, &models.FileEvent{}, &models.UserEvent{}, &models.Organization{}, &models.Design{}, &models.QueueWorker{}); err != nil {
 		panic(fmt.Sprintf("failed to migrate database: %v", err))
 	}
 
@@ -123,62 +246,87 @@ func ServeWebApp() {
 	var org models.Organization
 	result := db.FirstOrCreate(&org, models.Organization{Name: "no_org"})
 	if result.Error != nil {
-		log.Printf("Error ensuring default organization exists: %v", result.Error)
+		slog.Error("Error ensuring default organization exists", "error", result.Error)
 	} else {
-		log.Println("Default organization ensured in database")
+		slog.Info("Default organization ensured in database")
 	}
 
-	stripeWebhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET_KEY")
-	log.Printf("Initial STRIPE_WEBHOOK_SECRET_KEY: %s\n", stripeWebhookSecret)
-
-	if stripeWebhookSecret == "" {
-		log.Println("STRIPE_WEBHOOK_SECRET_KEY not set, attempting to read from file")
-		stripeWebhookSecretBytes, err := os.ReadFile("/var/secrets/stripe/secret.txt")
-		if err != nil {
-			log.Fatalf("Failed to read Stripe webhook signing secret: %v", err)
-		}
-		stripeWebhookSecret = strings.TrimSpace(string(stripeWebhookSecretBytes))
+	return db
+}
 
-		os.Setenv("STRIPE_WEBHOOK_SECRET_KEY", stripeWebhookSecret)
-		log.Printf("STRIPE_WEBHOOK_SECRET_KEY set from file: %s\n", stripeWebhookSecret)
-	} else {
-		log.Println("STRIPE_WEBHOOK_SECRET_KEY is already set")
+// startBackgroundJobs starts the queue workers, the running job monitor and
+// the other loops that move jobs along. Once ctx is cancelled no new jobs are
+// claimed. The returned function waits for the workers to finish their jobs
+// until its context expires, releases the jobs still claimed and stops the
+// loops.
+func startBackgroundJobs(ctx context.Context, cfg *config.Config, db *gorm.DB) func(context.Context) {
+	maxWorkers := cfg.Queue.MaxWorkers
+	schedulingPolicy := utils.SchedulingPolicy{
+		MaxJobsPerUser:       cfg.Queue.MaxJobsPerUser,
+		MaxJobsPerPaidUser:   cfg.Queue.MaxJobsPerPaidUser,
+		PaidTierWeight:       cfg.Queue.PaidTierWeight,
+		PriorityAgingSeconds: cfg.Queue.PriorityAgingSeconds,
+		Capacity:             utils.ClusterCapacityFromConfig(cfg.Queue),
+		RayAdmissionControl:  cfg.Ray.AdmissionControl,
 	}
 
-	// Set up CORS
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   []string{os.Getenv("FRONTEND_URL"), "http://localhost:3000", "http://frontend:3000"},
-		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "PUT"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Requested-With"},
-	})
-
-	mux := server.NewServer(db, s3Client)
+	if err := utils.ReconcileJobsOnStartup(db); err != nil {
+		slog.Error("Error reconciling jobs on startup", "error", err)
+	}
 
-	maxWorkers := utils.GetEnvAsInt("MAX_WORKERS", 4)
+	queue, err := utils.StartJobQueues(ctx, db, maxWorkers, schedulingPolicy)
+	if err != nil {
+		fatal("Failed to start job queues", "error", err)
+	}
 
-	// Start queue watcher in a separate goroutine
+	var loops sync.WaitGroup
+	loops.Add(3)
 	go func() {
+		defer loops.Done()
 		for {
-			if err := utils.StartJobQueues(db, maxWorkers); err != nil {
-				fmt.Printf("unexpected error processing job queues: %v\n", err)
-				time.Sleep(5 * time.Second) // wait for 5 seconds before retrying
+			if err := utils.MonitorRunningJobs(ctx, db); err != nil {
+				slog.Error("Unexpected error monitoring running jobs", "error", err)
+				time.Sleep(10 * time.Second) // wait before retrying
+			} else {
+				break // exit the loop if no error (optional based on your use case)
 			}
 		}
 	}()
 
+	// Leases are renewed until the workers are done with their jobs
+	leasesCtx, stopLeases := context.WithCancel(context.Background())
 	go func() {
-		for {
-			if err := utils.MonitorRunningJobs(db); err != nil {
-				fmt.Printf("unexpected error monitoring running jobs: %v\n", err)
-				time.Sleep(10 * time.Second) // wait for 5 seconds before retrying
-			} else {
-				break // exit the loop if no error (optional based on your use case)
-			}
+		defer loops.Done()
+		if err := utils.MaintainJobLeases(leasesCtx, db); err != nil {
+			slog.Error("Unexpected error maintaining job leases", "error", err)
 		}
 	}()
 
-	// Start the server with CORS middleware
-	fmt.Println("Server started on http://localhost:8080")
-	http.ListenAndServe(":8080", corsMiddleware.Handler(mux))
+	go func() {
+		defer loops.Done()
+		if err := utils.ReapTimedOutJobs(ctx, db); err != nil {
+			slog.Error("Unexpected error reaping timed out jobs", "error", err)
+		}
+	}()
+
+	return func(shutdownCtx context.Context) {
+		if err := queue.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Releasing the jobs of workers that are still busy", "error", err)
+		}
+		stopLeases()
+		if err := utils.ReleaseClaimedJobs(db); err != nil {
+			slog.Error("Error releasing claimed jobs", "error", err)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			loops.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			slog.Warn("Background jobs did not stop in time")
+		}
+	}
 }
@@ -0,0 +1,369 @@
+// Package config holds the settings of `plex web` and `plex worker`. They are
+// read once at startup from a JSON file and the environment, where variables
+// take precedence, and validated before anything else starts.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FileEnv names a JSON config file to load when none is passed explicitly.
+const FileEnv = "PLEX_CONFIG_FILE"
+
+// Fields tagged with env are read from that variable when it is set. Fields
+// tagged with secret are masked by Masked.
+type Config struct {
+	Server   Server   `json:"server"`
+	Database Database `json:"database"`
+	Bucket   Bucket   `json:"bucket"`
+	Auth     Auth     `json:"auth"`
+	Stripe   Stripe   `json:"stripe"`
+	Ray      Ray      `json:"ray"`
+	Queue    Queue    `json:"queue"`
+	Web3     Web3     `json:"web3"`
+}
+
+type Server struct {
+	Addr        string `json:"addr" env:"GATEWAY_ADDR"`
+	FrontendURL string `json:"frontendUrl" env:"FRONTEND_URL"`
+	// MetricsAddr is where the web app serves /metrics, apart from the API
+	// so it is not reachable from the internet. Empty turns it off.
+	MetricsAddr string `json:"metricsAddr" env:"METRICS_ADDR"`
+	// WorkerMetricsAddr is where `plex worker` serves /metrics, if set.
+	WorkerMetricsAddr      string `json:"workerMetricsAddr" env:"WORKER_METRICS_ADDR"`
+	ShutdownTimeoutSeconds int    `json:"shutdownTimeoutSeconds" env:"SHUTDOWN_TIMEOUT_SECONDS"`
+}
+
+type Database struct {
+	Host     string `json:"host" env:"POSTGRES_HOST"`
+	User     string `json:"user" env:"POSTGRES_USER"`
+	Password string `json:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	Name     string `json:"name" env:"POSTGRES_DB"`
+}
+
+type Bucket struct {
+	Name            string `json:"name" env:"BUCKET_NAME"`
+	Endpoint        string `json:"endpoint" env:"BUCKET_ENDPOINT"`
+	Region          string `json:"region" env:"AWS_REGION"`
+	UseSSL          bool   `json:"useSsl" env:"USE_SSL"`
+	AccessKeyID     string `json:"accessKeyId" env:"BUCKET_ACCESS_KEY_ID"`
+	SecretAccessKey string `json:"secretAccessKey" env:"BUCKET_SECRET_ACCESS_KEY" secret:"true"`
+}
+
+type Auth struct {
+	PrivyAppID     string `json:"privyAppId" env:"NEXT_PUBLIC_PRIVY_APP_ID"`
+	PrivyPublicKey string `json:"privyPublicKey" env:"PRIVY_PUBLIC_KEY"`
+}
+
+type Stripe struct {
+	SecretKey     string `json:"secretKey" env:"STRIPE_SECRET_KEY" secret:"true"`
+	WebhookSecret string `json:"webhookSecret" env:"STRIPE_WEBHOOK_SECRET_KEY" secret:"true"`
+	// WebhookSecretFile is read when WebhookSecret is not set, e.g. a
+	// mounted Kubernetes secret.
+	WebhookSecretFile string `json:"webhookSecretFile" env:"STRIPE_WEBHOOK_SECRET_FILE"`
+	PriceID           string `json:"priceId" env:"STRIPE_PRICE_ID"`
+	// TierThreshold is the compute tally at which free users are asked to
+	// subscribe.
+	TierThreshold int `json:"tierThreshold" env:"TIER_THRESHOLD"`
+}
+
+type Ray struct {
+	APIHost    string `json:"apiHost" env:"RAY_API_HOST"`
+	JobAPIHost string `json:"jobApiHost" env:"RAY_JOB_API_HOST"`
+	// Backend overrides the job backend of every model, "ray" or "exec".
+	Backend               string `json:"backend" env:"JOB_BACKEND"`
+	ExecWorkDir           string `json:"execWorkDir" env:"EXEC_BACKEND_WORKDIR"`
+	ExecOutputDir         string `json:"execOutputDir" env:"EXEC_BACKEND_OUTPUT_DIR"`
+	AdmissionControl      bool   `json:"admissionControl" env:"RAY_ADMISSION_CONTROL"`
+	ResourceSettleSeconds int    `json:"resourceSettleSeconds" env:"RAY_RESOURCE_SETTLE_SECONDS"`
+	CallbackBaseURL       string `json:"callbackBaseUrl" env:"JOB_CALLBACK_BASE_URL"`
+	CallbackSecret        string `json:"callbackSecret" env:"JOB_CALLBACK_SECRET" secret:"true"`
+}
+
+type Queue struct {
+	// RunWorkers makes `plex web` run the queue workers too.
+	RunWorkers             bool    `json:"runWorkers" env:"GATEWAY_RUN_WORKERS"`
+	InstanceID             string  `json:"instanceId" env:"GATEWAY_INSTANCE_ID"`
+	MaxWorkers             int     `json:"maxWorkers" env:"MAX_WORKERS"`
+	MaxJobsPerUser         int     `json:"maxJobsPerUser" env:"MAX_JOBS_PER_USER"`
+	MaxJobsPerPaidUser     int     `json:"maxJobsPerPaidUser" env:"MAX_JOBS_PER_PAID_USER"`
+	PaidTierWeight         int     `json:"paidTierWeight" env:"PAID_TIER_WEIGHT"`
+	PriorityAgingSeconds   int     `json:"priorityAgingSeconds" env:"PRIORITY_AGING_SECONDS"`
+	MaxJobPriorityFree     int     `json:"maxJobPriorityFree" env:"MAX_JOB_PRIORITY_FREE"`
+	MaxJobPriorityPaid     int     `json:"maxJobPriorityPaid" env:"MAX_JOB_PRIORITY_PAID"`
+	DefaultJobPriorityPaid int     `json:"defaultJobPriorityPaid" env:"DEFAULT_JOB_PRIORITY_PAID"`
+	MaxRetries             int     `json:"maxRetries" env:"MAX_RETRY_COUNT_FOR_500"`
+	MaxRetriesFor404       int     `json:"maxRetriesFor404" env:"MAX_RETRY_COUNT_FOR_404"`
+	JobLeaseSeconds        int     `json:"jobLeaseSeconds" env:"JOB_LEASE_SECONDS"`
+	HeartbeatSeconds       int     `json:"heartbeatSeconds" env:"WORKER_HEARTBEAT_SECONDS"`
+	MonitorIntervalSeconds int     `json:"monitorIntervalSeconds" env:"JOB_MONITOR_INTERVAL_SECONDS"`
+	ReaperIntervalSeconds  int     `json:"reaperIntervalSeconds" env:"REAPER_INTERVAL_SECONDS"`
+	ErrorLogLines          int     `json:"errorLogLines" env:"JOB_ERROR_LOG_LINES"`
+	ClusterCPUCapacity     float64 `json:"clusterCpuCapacity" env:"CLUSTER_CPU_CAPACITY"`
+	ClusterMemoryGbCap     int     `json:"clusterMemoryGbCapacity" env:"CLUSTER_MEMORY_GB_CAPACITY"`
+	ClusterGPUCapacity     int     `json:"clusterGpuCapacity" env:"CLUSTER_GPU_CAPACITY"`
+}
+
+type Web3 struct {
+	AutotaskWebhook string `json:"autotaskWebhook" env:"AUTOTASK_WEBHOOK" secret:"true"`
+	PinataAPIToken  string `json:"pinataApiToken" env:"PINATA_API_TOKEN" secret:"true"`
+}
+
+// Default returns the settings used for everything that is not configured.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:                   ":8080",
+			MetricsAddr:            ":9090",
+			ShutdownTimeoutSeconds: 30,
+		},
+		Stripe: Stripe{
+			WebhookSecretFile: "/var/secrets/stripe/secret.txt",
+		},
+		Ray: Ray{
+			APIHost:               "http://localhost:8000",
+			JobAPIHost:            "http://localhost:8265",
+			ExecWorkDir:           ".",
+			ResourceSettleSeconds: 60,
+		},
+		Queue: Queue{
+			RunWorkers:             true,
+			MaxWorkers:             4,
+			PaidTierWeight:         2,
+			PriorityAgingSeconds:   600, // a queued job gains one priority level every 10 minutes
+			MaxJobPriorityPaid:     10,
+			DefaultJobPriorityPaid: 5,
+			MaxRetries:             2,
+			MaxRetriesFor404:       1,
+			JobLeaseSeconds:        60,
+			HeartbeatSeconds:       15,
+			MonitorIntervalSeconds: 10,
+			ReaperIntervalSeconds:  60,
+			ErrorLogLines:          20,
+		},
+	}
+}
+
+// Load reads the defaults, then the JSON file at path if one is given or
+// named by PLEX_CONFIG_FILE, then the environment. It does not validate the
+// result, see Validate.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening config file: %w", err)
+		}
+		defer file.Close()
+		if err := decodeFile(file, cfg); err != nil {
+			return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if cfg.Stripe.WebhookSecret == "" && cfg.Stripe.WebhookSecretFile != "" {
+		secret, err := os.ReadFile(cfg.Stripe.WebhookSecretFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error reading Stripe webhook secret file: %w", err)
+		}
+		cfg.Stripe.WebhookSecret = strings.TrimSpace(string(secret))
+	}
+	return cfg, nil
+}
+
+// decodeFile rejects unknown keys, a typo must not fall back to a default.
+func decodeFile(r io.Reader, cfg *Config) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		structField := v.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, lookup); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		name := structField.Tag.Get("env")
+		value, ok := lookup(name)
+		if name == "" || !ok || value == "" {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(parsed)
+	default:
+		return fmt.Errorf("unsupported type %v", field.Kind())
+	}
+	return nil
+}
+
+// Validate checks the settings both `plex web` and `plex worker` need and
+// reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(value string, name string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	atLeast := func(value int, min int, name string) {
+		if value < min {
+			errs = append(errs, fmt.Errorf("%s must be at least %d, got %d", name, min, value))
+		}
+	}
+	validURL := func(value string, name string) {
+		if value == "" {
+			return
+		}
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an http(s) URL, got %q", name, value))
+		}
+	}
+
+	required(c.Database.Host, "POSTGRES_HOST")
+	required(c.Database.User, "POSTGRES_USER")
+	required(c.Database.Name, "POSTGRES_DB")
+	required(c.Bucket.Name, "BUCKET_NAME")
+
+	atLeast(c.Server.ShutdownTimeoutSeconds, 1, "SHUTDOWN_TIMEOUT_SECONDS")
+	validURL(c.Server.FrontendURL, "FRONTEND_URL")
+
+	validURL(c.Ray.APIHost, "RAY_API_HOST")
+	validURL(c.Ray.JobAPIHost, "RAY_JOB_API_HOST")
+	validURL(c.Ray.CallbackBaseURL, "JOB_CALLBACK_BASE_URL")
+	switch c.Ray.Backend {
+	case "", "ray", "exec":
+	default:
+		errs = append(errs, fmt.Errorf("JOB_BACKEND must be ray or exec, got %q", c.Ray.Backend))
+	}
+	if c.Ray.CallbackBaseURL != "" && c.Ray.CallbackSecret == "" {
+		errs = append(errs, errors.New("JOB_CALLBACK_SECRET is required when JOB_CALLBACK_BASE_URL is set"))
+	}
+	atLeast(c.Ray.ResourceSettleSeconds, 0, "RAY_RESOURCE_SETTLE_SECONDS")
+
+	atLeast(c.Queue.MaxWorkers, 1, "MAX_WORKERS")
+	atLeast(c.Queue.MaxJobsPerUser, 0, "MAX_JOBS_PER_USER")
+	atLeast(c.Queue.MaxJobsPerPaidUser, 0, "MAX_JOBS_PER_PAID_USER")
+	atLeast(c.Queue.PaidTierWeight, 1, "PAID_TIER_WEIGHT")
+	atLeast(c.Queue.PriorityAgingSeconds, 0, "PRIORITY_AGING_SECONDS")
+	atLeast(c.Queue.MaxJobPriorityFree, 0, "MAX_JOB_PRIORITY_FREE")
+	atLeast(c.Queue.MaxJobPriorityPaid, 0, "MAX_JOB_PRIORITY_PAID")
+	if c.Queue.DefaultJobPriorityPaid < 0 || c.Queue.DefaultJobPriorityPaid > c.Queue.MaxJobPriorityPaid {
+		errs = append(errs, fmt.Errorf("DEFAULT_JOB_PRIORITY_PAID must be between 0 and MAX_JOB_PRIORITY_PAID (%d), got %d", c.Queue.MaxJobPriorityPaid, c.Queue.DefaultJobPriorityPaid))
+	}
+	atLeast(c.Queue.MaxRetries, 0, "MAX_RETRY_COUNT_FOR_500")
+	atLeast(c.Queue.MaxRetriesFor404, 0, "MAX_RETRY_COUNT_FOR_404")
+	atLeast(c.Queue.JobLeaseSeconds, 1, "JOB_LEASE_SECONDS")
+	atLeast(c.Queue.HeartbeatSeconds, 1, "WORKER_HEARTBEAT_SECONDS")
+	atLeast(c.Queue.MonitorIntervalSeconds, 1, "JOB_MONITOR_INTERVAL_SECONDS")
+	atLeast(c.Queue.ReaperIntervalSeconds, 1, "REAPER_INTERVAL_SECONDS")
+	atLeast(c.Queue.ErrorLogLines, 0, "JOB_ERROR_LOG_LINES")
+	if c.Queue.ClusterCPUCapacity < 0 {
+		errs = append(errs, fmt.Errorf("CLUSTER_CPU_CAPACITY must not be negative, got %v", c.Queue.ClusterCPUCapacity))
+	}
+	atLeast(c.Queue.ClusterMemoryGbCap, 0, "CLUSTER_MEMORY_GB_CAPACITY")
+	atLeast(c.Queue.ClusterGPUCapacity, 0, "CLUSTER_GPU_CAPACITY")
+
+	validURL(c.Web3.AutotaskWebhook, "AUTOTASK_WEBHOOK")
+
+	return errors.Join(errs...)
+}
+
+// ValidateWeb is Validate plus the settings only the web app needs.
+func (c *Config) ValidateWeb() error {
+	var errs []error
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("GATEWAY_ADDR is required"))
+	}
+	if c.Stripe.TierThreshold < 1 {
+		errs = append(errs, fmt.Errorf("TIER_THRESHOLD must be at least 1, got %d", c.Stripe.TierThreshold))
+	}
+	if c.Stripe.WebhookSecret == "" {
+		errs = append(errs, fmt.Errorf("STRIPE_WEBHOOK_SECRET_KEY is required, or a secret in %s", c.Stripe.WebhookSecretFile))
+	}
+	return errors.Join(errs...)
+}
+
+const masked = "[REDACTED]"
+
+// Masked returns a copy of c with every secret that is set replaced, so it
+// can be printed or logged.
+func (c *Config) Masked() *Config {
+	copied := *c
+	maskSecrets(reflect.ValueOf(&copied).Elem())
+	return &copied
+}
+
+func maskSecrets(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			maskSecrets(field)
+			continue
+		}
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(masked)
+		}
+	}
+}
+
+// Print writes the masked config as JSON, in the format Load reads.
+func (c *Config) Print(w io.Writer) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c.Masked()); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
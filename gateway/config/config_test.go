@@ -0,0 +1,271 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cases := map[string]struct {
+		file  string
+		env   map[string]string
+		check func(*Config) bool
+		err   string
+	}{
+		"defaults": {
+			check: func(c *Config) bool { return c.Server.Addr == ":8080" && c.Queue.MaxWorkers == 4 && c.Queue.RunWorkers },
+		},
+		"file": {
+			file: `{"database": {"host": "db"}, "queue": {"maxWorkers": 8}}`,
+			check: func(c *Config) bool {
+				return c.Database.Host == "db" && c.Queue.MaxWorkers == 8 && c.Server.Addr == ":8080"
+			},
+		},
+		"env overrides file": {
+			file: `{"database": {"host": "db"}, "queue": {"maxWorkers": 8, "runWorkers": true}}`,
+			env:  map[string]string{"POSTGRES_HOST": "other", "MAX_WORKERS": "2", "GATEWAY_RUN_WORKERS": "false", "CLUSTER_CPU_CAPACITY": "1.5", "MAX_RETRY_COUNT_FOR_404": "3"},
+			check: func(c *Config) bool {
+				return c.Database.Host == "other" && c.Queue.MaxWorkers == 2 && !c.Queue.RunWorkers && c.Queue.ClusterCPUCapacity == 1.5 && c.Queue.MaxRetriesFor404 == 3
+			},
+		},
+		"empty env keeps file": {
+			file:  `{"database": {"host": "db"}}`,
+			env:   map[string]string{"POSTGRES_HOST": ""},
+			check: func(c *Config) bool { return c.Database.Host == "db" },
+		},
+		"bad int": {
+			env: map[string]string{"MAX_WORKERS": "four"},
+			err: `MAX_WORKERS: invalid integer "four"`,
+		},
+		"bad bool": {
+			env: map[string]string{"USE_SSL": "maybe"},
+			err: `USE_SSL: invalid boolean "maybe"`,
+		},
+		"bad float": {
+			env: map[string]string{"CLUSTER_CPU_CAPACITY": "lots"},
+			err: `CLUSTER_CPU_CAPACITY: invalid number "lots"`,
+		},
+		"unknown key": {
+			file: `{"queue": {"maxWorker": 8}}`,
+			err:  `unknown field "maxWorker"`,
+		},
+		"wrong type in file": {
+			file: `{"queue": {"maxWorkers": "8"}}`,
+			err:  "error reading config file",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(FileEnv, "")
+			for key, value := range c.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if c.file != "" {
+				path = writeConfigFile(t, c.file)
+			}
+			cfg, err := Load(path)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected an error containing %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadReadsFileFromEnv(t *testing.T) {
+	t.Setenv(FileEnv, writeConfigFile(t, `{"bucket": {"name": "data"}}`))
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Bucket.Name != "data" {
+		t.Errorf("bucket name = %q, want data", cfg.Bucket.Name)
+	}
+}
+
+func TestLoadReportsEveryBadVariable(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	t.Setenv("MAX_WORKERS", "four")
+	t.Setenv("USE_SSL", "maybe")
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, name := range []string{"MAX_WORKERS", "USE_SSL"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+}
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Database = Database{Host: "db", User: "plex", Name: "plex"}
+	cfg.Bucket.Name = "data"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	cases := map[string]struct {
+		change func(*Config)
+		errs   []string
+	}{
+		"valid": {
+			change: func(*Config) {},
+		},
+		"missing settings": {
+			change: func(c *Config) { c.Database = Database{} },
+			errs:   []string{"POSTGRES_HOST is required", "POSTGRES_USER is required", "POSTGRES_DB is required"},
+		},
+		"every problem": {
+			change: func(c *Config) {
+				c.Bucket.Name = ""
+				c.Queue.MaxWorkers = 0
+				c.Ray.Backend = "slurm"
+				c.Ray.JobAPIHost = "localhost:8265"
+				c.Ray.CallbackBaseURL = "https://plex.example"
+				c.Queue.DefaultJobPriorityPaid = 11
+			},
+			errs: []string{
+				"BUCKET_NAME is required",
+				"MAX_WORKERS must be at least 1, got 0",
+				`JOB_BACKEND must be ray or exec, got "slurm"`,
+				`RAY_JOB_API_HOST must be an http(s) URL, got "localhost:8265"`,
+				"JOB_CALLBACK_SECRET is required when JOB_CALLBACK_BASE_URL is set",
+				"DEFAULT_JOB_PRIORITY_PAID must be between 0 and MAX_JOB_PRIORITY_PAID (10), got 11",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := validConfig()
+			c.change(cfg)
+			err := cfg.Validate()
+			if len(c.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(c.errs) {
+				t.Errorf("got %d errors, want %d: %v", len(lines), len(c.errs), err)
+			}
+			for _, expected := range c.errs {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("error %q does not contain %q", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateWebIncludesValidate(t *testing.T) {
+	cfg := validConfig()
+	cfg.Bucket.Name = ""
+	cfg.Server.Addr = ""
+	err := cfg.ValidateWeb()
+	for _, expected := range []string{"BUCKET_NAME is required", "GATEWAY_ADDR is required", "STRIPE_WEBHOOK_SECRET_KEY is required"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %v does not contain %q", err, expected)
+		}
+	}
+}
+
+func TestMaskedHidesSecrets(t *testing.T) {
+	secrets := []string{"db-password", "bucket-secret", "sk_live_key", "whsec_key", "callback-secret", "https://autotask.example/hook", "pinata-token"}
+	cfg := validConfig()
+	cfg.Database.Password = secrets[0]
+	cfg.Bucket.SecretAccessKey = secrets[1]
+	cfg.Stripe.SecretKey = secrets[2]
+	cfg.Stripe.WebhookSecret = secrets[3]
+	cfg.Ray.CallbackSecret = secrets[4]
+	cfg.Web3.AutotaskWebhook = secrets[5]
+	cfg.Web3.PinataAPIToken = secrets[6]
+
+	cases := map[string]func() (string, error){
+		"masked": func() (string, error) {
+			masked := cfg.Masked()
+			if masked.Database.Password != "[REDACTED]" || masked.Database.Host != "db" {
+				return "", errors.New("secrets are not masked or settings are lost")
+			}
+			var buf bytes.Buffer
+			err := masked.Print(&buf)
+			return buf.String(), err
+		},
+		"print": func() (string, error) {
+			var buf bytes.Buffer
+			err := cfg.Print(&buf)
+			return buf.String(), err
+		},
+	}
+	for name, output := range cases {
+		t.Run(name, func(t *testing.T) {
+			printed, err := output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range secrets {
+				if strings.Contains(printed, secret) {
+					t.Errorf("output contains the secret %q", secret)
+				}
+			}
+			if !strings.Contains(printed, `"host": "db"`) {
+				t.Errorf("output lacks the settings that are not secret:\n%s", printed)
+			}
+		})
+	}
+
+	if cfg.Database.Password != secrets[0] {
+		t.Errorf("Masked changed the original config")
+	}
+	var buf bytes.Buffer
+	if err := Default().Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "[REDACTED]") {
+		t.Errorf("unset secrets should stay empty:\n%s", buf.String())
+	}
+}
+
+func TestPrintIsLoadable(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	cfg := validConfig()
+	cfg.Queue.MaxWorkers = 7
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(writeConfigFile(t, buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Queue.MaxWorkers != 7 || loaded.Database.Host != "db" {
+		t.Errorf("unexpected config %+v", loaded)
+	}
+}
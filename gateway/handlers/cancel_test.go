@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/middleware"
+	"github.com/labdao/plex/gateway/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testWallet = "0x0000000000000000000000000000000000000001"
+
+// newCancelTestDB returns a database with a queued job in an experiment of
+// testWallet.
+func newCancelTestDB(t *testing.T) (*gorm.DB, models.Job) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "plex.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Model{}, &models.Experiment{}, &models.Job{}, &models.InferenceEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	model := models.Model{Name: "model", WalletAddress: testWallet}
+	experiment := models.Experiment{Name: "experiment", WalletAddress: testWallet}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&experiment).Error; err != nil {
+		t.Fatal(err)
+	}
+	job := models.Job{JobStatus: models.JobStateQueued, ExperimentID: experiment.ID, ModelID: model.ID, WalletAddress: testWallet}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, job
+}
+
+func TestCancelHandlers(t *testing.T) {
+	cases := map[string]struct {
+		method string
+		status int
+	}{
+		"post":   {http.MethodPost, http.StatusOK},
+		"delete": {http.MethodDelete, http.StatusOK},
+		"get":    {http.MethodGet, http.StatusBadRequest},
+	}
+	handlers := map[string]struct {
+		handler func(*gorm.DB) http.HandlerFunc
+		vars    func(models.Job) map[string]string
+	}{
+		"job": {CancelJobHandler, func(job models.Job) map[string]string {
+			return map[string]string{"jobID": fmt.Sprint(job.ID)}
+		}},
+		"experiment": {CancelExperimentHandler, func(job models.Job) map[string]string {
+			return map[string]string{"experimentID": fmt.Sprint(job.ExperimentID)}
+		}},
+	}
+	for handlerName, h := range handlers {
+		for name, c := range cases {
+			t.Run(handlerName+" "+name, func(t *testing.T) {
+				db, job := newCancelTestDB(t)
+				r := httptest.NewRequest(c.method, "/cancel", nil)
+				r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, &models.User{WalletAddress: testWallet}))
+				r = mux.SetURLVars(r, h.vars(job))
+				w := httptest.NewRecorder()
+				h.handler(db).ServeHTTP(w, r)
+
+				if w.Code != c.status {
+					t.Fatalf("status = %d, want %d: %s", w.Code, c.status, w.Body)
+				}
+				expected := models.JobStateQueued
+				if c.status == http.StatusOK {
+					expected = models.JobStateStopped
+				}
+				var cancelled models.Job
+				if err := db.First(&cancelled, job.ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				if cancelled.JobStatus != expected {
+					t.Errorf("job status = %s, want %s", cancelled.JobStatus, expected)
+				}
+			})
+		}
+	}
+}
@@ -4,12 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/internal/ipwl"
 
@@ -90,8 +91,7 @@ type ExperimentListCheckpointsResult struct {
 	ModelJson     []byte `gorm:"column:model_json"`
 }
 
-func fetchJobScatterPlotData(experimentListCheckpointsResult ExperimentListCheckpointsResult, db *gorm.DB) ([]models.ScatterPlotData, error) {
-	bucketName := os.Getenv("BUCKET_NAME")
+func fetchJobScatterPlotData(experimentListCheckpointsResult ExperimentListCheckpointsResult, bucketName string, db *gorm.DB) ([]models.ScatterPlotData, error) {
 	var ModelJson ipwl.Model
 	if err := json.Unmarshal(experimentListCheckpointsResult.ModelJson, &ModelJson); err != nil {
 		return nil, err
@@ -103,7 +103,7 @@ func fetchJobScatterPlotData(experimentListCheckpointsResult ExperimentListCheck
 	if string(experimentListCheckpointsResult.JobResultJson) != "" {
 		resultJSON, err := UnmarshalRayJobResponse([]byte(experimentListCheckpointsResult.JobResultJson))
 		if err != nil {
-			fmt.Println("Error unmarshalling result JSON:", err)
+			slog.Error("Error unmarshalling result JSON", "job_id", experimentListCheckpointsResult.JobID, "error", err)
 			return nil, err
 		}
 
@@ -151,7 +151,7 @@ func fetchJobScatterPlotData(experimentListCheckpointsResult ExperimentListCheck
 	}
 }
 
-func GetExperimentCheckpointDataHandler(db *gorm.DB) http.HandlerFunc {
+func GetExperimentCheckpointDataHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		experimentID := vars["experimentID"]
@@ -199,7 +199,7 @@ func GetExperimentCheckpointDataHandler(db *gorm.DB) http.HandlerFunc {
 		var allPlotData []models.ScatterPlotData
 
 		for _, job := range experimentListCheckpointsResult {
-			plotData, err := fetchJobScatterPlotData(job, db) // Adjust function to accept the new job structure
+			plotData, err := fetchJobScatterPlotData(job, cfg.Bucket.Name, db) // Adjust function to accept the new job structure
 			if err != nil {
 				http.Error(w, "Failed to fetch scatter plot data for a job", http.StatusInternalServerError)
 				return
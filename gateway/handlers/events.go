@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/middleware"
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
+	"gorm.io/gorm"
+)
+
+const eventStreamPollInterval = 2 * time.Second
+const eventStreamKeepAliveInterval = 15 * time.Second
+const eventStreamBatchSize = 100
+
+var finalJobStates = []models.JobState{models.JobStateStopped, models.JobStateSucceeded, models.JobStateFailed}
+
+type shutdownKey struct{}
+
+// WithShutdown returns the base context for the server's requests. Streams
+// end once shutdown is closed, instead of holding up the server's shutdown
+// until their clients go away.
+func WithShutdown(ctx context.Context, shutdown <-chan struct{}) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, shutdown)
+}
+
+// serverShutdown is closed when the server shuts down. It is nil, and never
+// ready, for servers without WithShutdown.
+func serverShutdown(r *http.Request) <-chan struct{} {
+	shutdown, _ := r.Context().Value(shutdownKey{}).(<-chan struct{})
+	return shutdown
+}
+
+type inferenceEventPayload struct {
+	ID           uint            `json:"id"`
+	JobID        uint            `json:"jobId"`
+	RayJobID     string          `json:"rayJobId"`
+	JobStatus    models.JobState `json:"jobStatus"`
+	EventType    string          `json:"eventType"`
+	EventTime    time.Time       `json:"eventTime"`
+	EventMessage string          `json:"eventMessage,omitempty"`
+	FileName     string          `json:"fileName,omitempty"`
+	RetryCount   int             `json:"retryCount"`
+}
+
+// streamInferenceEvents writes the InferenceEvents selected by events as
+// Server-Sent Events until finished reports that nothing more will happen or
+// the client goes away. A reconnecting client resumes after Last-Event-ID.
+func streamInferenceEvents(w http.ResponseWriter, r *http.Request, events func() *gorm.DB, finished func() (bool, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.SendJSONError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastEventID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		lastEventID, _ = strconv.ParseUint(header, 10, 64)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(eventStreamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(eventStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		// Check first, so events written right before the end are still sent
+		done, err := finished()
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error checking event stream state", "error", err)
+			return
+		}
+
+		for {
+			var batch []models.InferenceEvent
+			err := events().
+				Where("inference_events.id > ?", lastEventID).
+				Order("inference_events.id ASC").
+				Limit(eventStreamBatchSize).
+				Find(&batch).Error
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Error fetching inference events", "error", err)
+				return
+			}
+			for _, event := range batch {
+				if err := writeInferenceEvent(w, event); err != nil {
+					return
+				}
+				lastEventID = uint64(event.ID)
+			}
+			flusher.Flush()
+			if len(batch) < eventStreamBatchSize {
+				break
+			}
+		}
+
+		if done {
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverShutdown(r):
+			// The client reconnects to another instance with Last-Event-ID
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-poll.C:
+		}
+	}
+}
+
+func writeInferenceEvent(w http.ResponseWriter, event models.InferenceEvent) error {
+	data, err := json.Marshal(inferenceEventPayload{
+		ID:           event.ID,
+		JobID:        event.JobID,
+		RayJobID:     event.RayJobID,
+		JobStatus:    event.JobStatus,
+		EventType:    event.EventType,
+		EventTime:    event.EventTime,
+		EventMessage: event.EventMessage,
+		FileName:     event.FileName,
+		RetryCount:   event.RetryCount,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.EventType, data)
+	return err
+}
+
+func StreamJobEventsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+		if !ok {
+			utils.SendJSONError(w, "User not found in context", http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		jobID, err := strconv.Atoi(params["jobID"])
+		if err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Job ID (%v) could not be converted to int", params["jobID"]), http.StatusNotFound)
+			return
+		}
+
+		var job models.Job
+		if result := db.First(&job, jobID); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Job not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Job: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if !job.Public && job.WalletAddress != user.WalletAddress && !user.Admin {
+			utils.SendJSONError(w, "Job not found or not authorized", http.StatusNotFound)
+			return
+		}
+
+		events := func() *gorm.DB {
+			return db.Model(&models.InferenceEvent{}).Where("inference_events.job_id = ?", job.ID)
+		}
+		finished := func() (bool, error) {
+			var count int64
+			err := db.Model(&models.Job{}).Where("id = ? AND job_status IN ?", job.ID, finalJobStates).Count(&count).Error
+			return count > 0, err
+		}
+		streamInferenceEvents(w, r, events, finished)
+	}
+}
+
+func StreamExperimentEventsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+		if !ok {
+			utils.SendJSONError(w, "User not found in context", http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		experimentID, err := strconv.Atoi(params["experimentID"])
+		if err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Experiment ID (%v) could not be converted to int", params["experimentID"]), http.StatusNotFound)
+			return
+		}
+
+		var experiment models.Experiment
+		if result := db.First(&experiment, experimentID); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Experiment not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Experiment: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if !experiment.Public && experiment.WalletAddress != user.WalletAddress && !user.Admin {
+			utils.SendJSONError(w, "Experiment not found or not authorized", http.StatusNotFound)
+			return
+		}
+
+		events := func() *gorm.DB {
+			return db.Model(&models.InferenceEvent{}).
+				Joins("JOIN jobs ON jobs.id = inference_events.job_id").
+				Where("jobs.experiment_id = ?", experiment.ID)
+		}
+		// The stream ends once no job of the experiment is left to finish
+		finished := func() (bool, error) {
+			var count int64
+			err := db.Model(&models.Job{}).Where("experiment_id = ? AND job_status NOT IN ?", experiment.ID, finalJobStates).Count(&count).Error
+			return count == 0, err
+		}
+		streamInferenceEvents(w, r, events, finished)
+	}
+}
@@ -5,32 +5,56 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
 	"github.com/labdao/plex/internal/ipwl"
+	"github.com/labdao/plex/internal/logging"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
-func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
+// sendInitializeIoError reports every invalid input of every job at once,
+// so users can fix a whole sweep in one go.
+func sendInitializeIoError(w http.ResponseWriter, err error) {
+	var inputErrors ipwl.InputErrors
+	if errors.As(err, &inputErrors) {
+		utils.SendJSONErrorWithDetails(w, "Invalid inputs", inputErrors, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ipwl.ErrInvalidScattering) {
+		utils.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utils.SendJSONError(w, fmt.Sprintf("Error while transforming validated JSON: %v", err), http.StatusInternalServerError)
+}
+
+// priorityErrorStatus is the status for a priority ResolveJobPriority
+// rejected: forbidden if a paid plan would allow it, a bad request otherwise.
+func priorityErrorStatus(err error) int {
+	if errors.Is(err, utils.ErrPaidJobPriority) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func AddExperimentHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Received Post request at /experiments")
+		logger := logging.FromContext(r.Context())
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			utils.SendJSONError(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 
-		log.Println("Request body: ", string(body))
+		logger.Debug("Request body", "body", string(body))
 
 		requestData := make(map[string]json.RawMessage)
 		err = json.Unmarshal(body, &requestData)
@@ -45,23 +69,53 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		var modelId int
-		err = json.Unmarshal(requestData["modelId"], &modelId)
-		if err != nil || modelId == 0 {
-			utils.SendJSONError(w, "Invalid or missing Model ID", http.StatusBadRequest)
-			return
-		}
-
+		// Jobs are pinned to the exact model version, either by ID or by a
+		// model name and version, which may be "latest"
 		var model models.Model
-		result := db.Where("id = ?", modelId).First(&model)
-		if result.Error != nil {
-			log.Printf("Error fetching Model: %v\n", result.Error)
-			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-				utils.SendJSONError(w, "Model not found", http.StatusNotFound)
-			} else {
-				utils.SendJSONError(w, "Error fetching Model", http.StatusInternalServerError)
+		if nameRaw, ok := requestData["modelName"]; ok {
+			var modelName, modelVersion string
+			if err := json.Unmarshal(nameRaw, &modelName); err != nil || modelName == "" {
+				utils.SendJSONError(w, "Invalid Model Name", http.StatusBadRequest)
+				return
+			}
+			if versionRaw, ok := requestData["modelVersion"]; ok {
+				if err := json.Unmarshal(versionRaw, &modelVersion); err != nil {
+					utils.SendJSONError(w, "Invalid Model Version", http.StatusBadRequest)
+					return
+				}
+			}
+			model, err = utils.ResolveModelVersion(db, modelName, modelVersion)
+			if err != nil {
+				logger.Error("Error resolving model version", "model_name", modelName, "model_version", modelVersion, "error", err)
+				if errors.Is(err, utils.ErrModelVersionNotFound) {
+					utils.SendJSONError(w, err.Error(), http.StatusNotFound)
+				} else {
+					utils.SendJSONError(w, "Error fetching Model", http.StatusInternalServerError)
+				}
+				return
+			}
+		} else {
+			var modelId int
+			err = json.Unmarshal(requestData["modelId"], &modelId)
+			if err != nil || modelId == 0 {
+				utils.SendJSONError(w, "Invalid or missing Model ID", http.StatusBadRequest)
+				return
+			}
+
+			result := db.Where("id = ?", modelId).First(&model)
+			if result.Error != nil {
+				logger.Error("Error fetching Model", "model_id", modelId, "error", result.Error)
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					utils.SendJSONError(w, "Model not found", http.StatusNotFound)
+				} else {
+					utils.SendJSONError(w, "Error fetching Model", http.StatusInternalServerError)
+				}
+				return
 			}
-			return
+		}
+		modelId := model.ID
+		if model.Deprecated {
+			logger.Warn("Experiment uses a deprecated model version", "model_name", model.Name, "model_version", model.Version)
 		}
 
 		var scatteringMethod string
@@ -71,6 +125,14 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		var scatteringOptions ipwl.ScatteringOptions
+		if optionsRaw, ok := requestData["scatteringOptions"]; ok {
+			if err := json.Unmarshal(optionsRaw, &scatteringOptions); err != nil {
+				utils.SendJSONError(w, "Invalid Scattering Options", http.StatusBadRequest)
+				return
+			}
+		}
+
 		var name string
 		err = json.Unmarshal(requestData["name"], &name)
 		if err != nil || name == "" {
@@ -78,16 +140,29 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		var requestedPriority *int
+		if priorityRaw, ok := requestData["priority"]; ok {
+			if err := json.Unmarshal(priorityRaw, &requestedPriority); err != nil {
+				utils.SendJSONError(w, "Invalid Priority", http.StatusBadRequest)
+				return
+			}
+		}
+		priority, err := utils.ResolveJobPriority(user, requestedPriority)
+		if err != nil {
+			utils.SendJSONError(w, err.Error(), priorityErrorStatus(err))
+			return
+		}
+
 		kwargsRaw, ok := requestData["kwargs"]
 		if !ok {
 			utils.SendJSONError(w, "missing kwargs in the request", http.StatusBadRequest)
 			return
 		}
 
-		var kwargs map[string][]interface{}
+		var kwargs map[string]interface{}
 		err = json.Unmarshal(kwargsRaw, &kwargs)
 		if err != nil {
-			log.Printf("Error unmarshalling kwargs: %v; Raw data: %s\n", err, string(kwargsRaw))
+			logger.Warn("Error unmarshalling kwargs", "kwargs", string(kwargsRaw), "error", err)
 			utils.SendJSONError(w, "Invalid structure for kwargs", http.StatusBadRequest)
 			return
 		}
@@ -98,12 +173,12 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		ioList, err := ipwl.InitializeIo(model.S3URI, scatteringMethod, kwargs, db)
+		ioList, err := ipwl.InitializeIo(r.Context(), model.S3URI, scatteringMethod, kwargs, scatteringOptions, db)
 		if err != nil {
-			utils.SendJSONError(w, fmt.Sprintf("Error while transforming validated JSON: %v", err), http.StatusInternalServerError)
+			sendInitializeIoError(w, err)
 			return
 		}
-		log.Println("Initialized IO List")
+		logger.Debug("Initialized IO List", "jobs", len(ioList))
 
 		experiment := models.Experiment{
 			WalletAddress: user.WalletAddress,
@@ -112,15 +187,13 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			Public:        false,
 		}
 
-		log.Println("Creating Experiment entry")
-		result = db.Create(&experiment)
+		result := db.Create(&experiment)
 		if result.Error != nil {
 			utils.SendJSONError(w, fmt.Sprintf("Error creating Experiment entity: %v", result.Error), http.StatusInternalServerError)
 			return
 		}
 
 		for _, ioItem := range ioList {
-			log.Println("Creating job entry")
 			inputsJSON, err := json.Marshal(ioItem.Inputs)
 			if err != nil {
 				utils.SendJSONError(w, fmt.Sprintf("Error transforming job inputs: %v", err), http.StatusInternalServerError)
@@ -134,7 +207,9 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				Inputs:        datatypes.JSON(inputsJSON),
 				CreatedAt:     time.Now().UTC(),
 				Public:        false,
+				TraceParent:   utils.Traceparent(r.Context()),
 				JobType:       model.JobType,
+				Priority:      priority,
 			}
 
 			result := db.Create(&job)
@@ -157,7 +232,6 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 						idsToAdd = append(idsToAdd, id)
 					}
 				case []interface{}:
-					fmt.Println("found slice, checking each for 'Qm' prefix")
 					for _, elem := range v {
 						strInput, ok := elem.(string)
 						if !ok {
@@ -192,6 +266,7 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				utils.SendJSONError(w, fmt.Sprintf("Error updating Job entity with input data: %v", result.Error), http.StatusInternalServerError)
 				return
 			}
+			logger.Info("Queued job", "job_id", job.ID, "experiment_id", experiment.ID)
 			inferenceEvent := models.InferenceEvent{
 				JobID:      job.ID,
 				RetryCount: 0,
@@ -212,19 +287,7 @@ func AddExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				return
 			}
 
-			thresholdStr := os.Getenv("TIER_THRESHOLD")
-			if thresholdStr == "" {
-				utils.SendJSONError(w, "TIER_THRESHOLD environment variable is not set", http.StatusInternalServerError)
-				return
-			}
-
-			threshold, err := strconv.Atoi(thresholdStr)
-			if err != nil {
-				utils.SendJSONError(w, fmt.Sprintf("Error converting TIER_THRESHOLD to integer: %v", err), http.StatusInternalServerError)
-				return
-			}
-
-			err = UpdateUserTier(db, user.WalletAddress, threshold)
+			err = UpdateUserTier(db, user.WalletAddress, cfg.Stripe.TierThreshold)
 			if err != nil {
 				utils.SendJSONError(w, fmt.Sprintf("Error updating user tier: %v", err), http.StatusInternalServerError)
 				return
@@ -341,7 +404,7 @@ func ListExperimentsHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		log.Println("Fetched experiments from DB: ", experiments)
+		logging.FromContext(r.Context()).Debug("Fetched experiments from DB", "count", len(experiments))
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(experiments); err != nil {
@@ -445,23 +508,24 @@ func UpdateExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				}
 			}
 
-			log.Println("Generating and storing RecordCID...")
+			logger := logging.FromContext(r.Context()).With("experiment_id", experiment.ID)
+			logger.Info("Generating and storing RecordCID")
 			metadataCID, err := utils.GenerateAndStoreRecordCID(db, &experiment)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Error generating and storing RecordCID: %v", err), http.StatusInternalServerError)
 				return
 			}
-			log.Printf("Generated and stored RecordCID: %s", metadataCID)
+			logger.Info("Generated and stored RecordCID", "cid", metadataCID)
 
-			log.Println("Minting NFT...")
+			logger.Info("Minting NFT")
 			if err := utils.MintNFT(db, &experiment, metadataCID); err != nil {
 				http.Error(w, fmt.Sprintf("Error minting NFT: %v", err), http.StatusInternalServerError)
 				return
 			}
-			log.Println("NFT minted")
+			logger.Info("NFT minted")
 		}
 
-		log.Printf("Updated Experiment: %+v", experiment)
+		logging.FromContext(r.Context()).Info("Updated Experiment", "experiment_id", experiment.ID)
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(experiment); err != nil {
@@ -471,16 +535,16 @@ func UpdateExperimentHandler(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
+func AddJobToExperimentHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Received Post request to add job to a experiment")
+		logger := logging.FromContext(r.Context())
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 
-		log.Println("Request body: ", string(body))
+		logger.Debug("Request body", "body", string(body))
 
 		requestData := make(map[string]json.RawMessage)
 		err = json.Unmarshal(body, &requestData)
@@ -536,15 +600,36 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		var scatteringOptions ipwl.ScatteringOptions
+		if optionsRaw, ok := requestData["scatteringOptions"]; ok {
+			if err := json.Unmarshal(optionsRaw, &scatteringOptions); err != nil {
+				http.Error(w, "Invalid Scattering Options", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var requestedPriority *int
+		if priorityRaw, ok := requestData["priority"]; ok {
+			if err := json.Unmarshal(priorityRaw, &requestedPriority); err != nil {
+				http.Error(w, "Invalid Priority", http.StatusBadRequest)
+				return
+			}
+		}
+		priority, err := utils.ResolveJobPriority(user, requestedPriority)
+		if err != nil {
+			http.Error(w, err.Error(), priorityErrorStatus(err))
+			return
+		}
+
 		kwargsRaw, ok := requestData["kwargs"]
 		if !ok {
 			http.Error(w, "missing kwargs in the request", http.StatusBadRequest)
 			return
 		}
-		var kwargs map[string][]interface{}
+		var kwargs map[string]interface{}
 		err = json.Unmarshal(kwargsRaw, &kwargs)
 		if err != nil {
-			log.Printf("Error unmarshalling kwargs: %v; Raw data: %s\n", err, string(kwargsRaw))
+			logger.Warn("Error unmarshalling kwargs", "kwargs", string(kwargsRaw), "error", err)
 			http.Error(w, "Invalid structure for kwargs", http.StatusBadRequest)
 			return
 		}
@@ -555,15 +640,14 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		ioList, err := ipwl.InitializeIo(model.S3URI, scatteringMethod, kwargs, db)
+		ioList, err := ipwl.InitializeIo(r.Context(), model.S3URI, scatteringMethod, kwargs, scatteringOptions, db)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error while transforming validated JSON: %v", err), http.StatusInternalServerError)
+			sendInitializeIoError(w, err)
 			return
 		}
-		log.Println("Initialized IO List")
+		logger.Debug("Initialized IO List", "jobs", len(ioList))
 
 		for _, ioItem := range ioList {
-			log.Println("Creating job entry")
 			inputsJSON, err := json.Marshal(ioItem.Inputs)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Error transforming job inputs: %v", err), http.StatusInternalServerError)
@@ -577,6 +661,8 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				Inputs:        datatypes.JSON(inputsJSON),
 				CreatedAt:     time.Now().UTC(),
 				Public:        false,
+				TraceParent:   utils.Traceparent(r.Context()),
+				Priority:      priority,
 			}
 
 			result = db.Create(&job)
@@ -599,7 +685,6 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 						cidsToAdd = append(cidsToAdd, id)
 					}
 				case []interface{}:
-					fmt.Println("found slice, checking each for 'Qm' prefix")
 					for _, elem := range v {
 						strInput, ok := elem.(string)
 						if !ok {
@@ -634,6 +719,7 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				http.Error(w, fmt.Sprintf("Error updating Job entity with input data: %v", result.Error), http.StatusInternalServerError)
 				return
 			}
+			logger.Info("Queued job", "job_id", job.ID, "experiment_id", experiment.ID)
 
 			inferenceEvent := models.InferenceEvent{
 				JobID:      job.ID,
@@ -655,19 +741,7 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 				return
 			}
 
-			thresholdStr := os.Getenv("TIER_THRESHOLD")
-			if thresholdStr == "" {
-				http.Error(w, "TIER_THRESHOLD environment variable is not set", http.StatusInternalServerError)
-				return
-			}
-
-			threshold, err := strconv.Atoi(thresholdStr)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error converting TIER_THRESHOLD to integer: %v", err), http.StatusInternalServerError)
-				return
-			}
-
-			err = UpdateUserTier(db, user.WalletAddress, threshold)
+			err = UpdateUserTier(db, user.WalletAddress, cfg.Stripe.TierThreshold)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Error updating user tier: %v", err), http.StatusInternalServerError)
 				return
@@ -681,3 +755,62 @@ func AddJobToExperimentHandler(db *gorm.DB) http.HandlerFunc {
 		}
 	}
 }
+
+func CancelExperimentHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			utils.SendJSONError(w, "Only POST and DELETE methods are supported", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+		if !ok {
+			utils.SendJSONError(w, "User not found in context", http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		experimentID, err := strconv.Atoi(params["experimentID"])
+		if err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Experiment ID (%v) could not be converted to int", params["experimentID"]), http.StatusNotFound)
+			return
+		}
+
+		var experiment models.Experiment
+		if result := db.Preload("Jobs").Where("id = ?", experimentID).First(&experiment); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Experiment not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Experiment: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if experiment.WalletAddress != user.WalletAddress && !user.Admin {
+			utils.SendJSONError(w, "Experiment not found or not authorized", http.StatusNotFound)
+			return
+		}
+
+		cancelledJobIDs := []uint{}
+		for _, job := range experiment.Jobs {
+			switch job.JobStatus {
+			case models.JobStateStopped, models.JobStateSucceeded, models.JobStateFailed:
+				continue
+			}
+			if _, err := utils.CancelJob(job.ID, db); err != nil {
+				if errors.Is(err, utils.ErrJobNotCancellable) {
+					continue
+				}
+				utils.SendJSONError(w, fmt.Sprintf("Error cancelling Job %v: %v", job.ID, err), http.StatusInternalServerError)
+				return
+			}
+			cancelledJobIDs = append(cancelledJobIDs, job.ID)
+		}
+		logging.FromContext(r.Context()).Info("Cancelled jobs of Experiment", "experiment_id", experiment.ID, "job_ids", cancelledJobIDs)
+
+		utils.SendJSONResponse(w, map[string]interface{}{
+			"experimentId":    experiment.ID,
+			"cancelledJobIds": cancelledJobIDs,
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
@@ -12,19 +13,19 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
 	"github.com/labdao/plex/internal/s3"
 
-	"log"
-
 	"gorm.io/gorm"
 )
 
-func AddFileHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
+func AddFileHandler(db *gorm.DB, s3c *s3.S3Client, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Received request to add file")
+		logger := logging.FromContext(r.Context())
 
 		if err := utils.CheckRequestMethod(r, http.MethodPost); err != nil {
 			utils.SendJSONError(w, err.Error(), http.StatusBadRequest)
@@ -36,7 +37,6 @@ func AddFileHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			utils.SendJSONError(w, "Error parsing multipart form", http.StatusBadRequest)
 			return
 		}
-		log.Println("Parsed multipart form")
 
 		retrievedFile, _, err := r.FormFile("file")
 		if err != nil {
@@ -51,8 +51,6 @@ func AddFileHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			return
 		}
 
-		walletAddress := user.WalletAddress
-
 		filename := r.FormValue("filename")
 		publicValue := r.FormValue("public")
 
@@ -68,7 +66,8 @@ func AddFileHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			isPublic = false
 		}
 
-		log.Printf("Received file upload request for file: %s, walletAddress: %s \n", filename, walletAddress)
+		logger = logger.With("filename", filename)
+		logger.Info("Received file upload request")
 
 		tempFile, err := utils.CreateAndWriteTempFile(retrievedFile, filename)
 		if err != nil {
@@ -76,18 +75,14 @@ func AddFileHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			return
 		}
 
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			utils.SendJSONError(w, "BUCKET_NAME environment variable not set", http.StatusInternalServerError)
-			return
-		}
+		bucketName := cfg.Bucket.Name
 
 		hash, err := utils.GenerateFileHash(tempFile.Name())
 		if err != nil {
 			utils.SendJSONError(w, fmt.Sprintf("Error generating hash: %v", err), http.StatusInternalServerError)
 			return
 		}
-		fmt.Println("Hash of", filename, "is", hash)
+		logger.Debug("Generated file hash", "hash", hash)
 		defer os.Remove(filename)
 
 		objectKey := hash + "/" + filename
@@ -389,17 +384,17 @@ func UpdateFileHandler(db *gorm.DB) http.HandlerFunc {
 }
 
 func AddTagsToFile(db *gorm.DB, fileID int, tagNames []string) error {
-	log.Println("Starting AddTagsToFile for File with ID:", fileID)
+	logger := slog.Default().With("file_id", fileID)
 
 	var file models.File
 	if err := db.Preload("Tags").Where("id = ?", fileID).First(&file).Error; err != nil {
-		log.Printf("Error finding File with ID %d: %v\n", fileID, err)
+		logger.Error("Error finding File", "error", err)
 		return fmt.Errorf("file not found: %v", err)
 	}
 
 	var tags []models.Tag
 	if err := db.Where("name IN ?", tagNames).Find(&tags).Error; err != nil {
-		log.Printf("Error finding tags: %v\n", err)
+		logger.Error("Error finding tags", "error", err)
 		return fmt.Errorf("error finding tags: %v", err)
 	}
 
@@ -408,20 +403,18 @@ func AddTagsToFile(db *gorm.DB, fileID int, tagNames []string) error {
 		existingTagMap[tag.Name] = true
 	}
 
-	log.Println("Adding tags:", tagNames)
 	for _, tag := range tags {
 		if !existingTagMap[tag.Name] {
 			file.Tags = append(file.Tags, tag)
 		}
 	}
 
-	log.Println("Saving File with new tags to DB")
 	if err := db.Save(&file).Error; err != nil {
-		log.Printf("error saving File with ID %d: %v\n", fileID, err)
+		logger.Error("Error saving File", "error", err)
 		return fmt.Errorf("error saving file: %v", err)
 	}
 
-	log.Println("File with ID", fileID, "successfully updated with new tags")
+	logger.Info("File updated with new tags", "tags", tagNames)
 
 	return nil
 }
@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"crypto/hmac"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
+	"github.com/labdao/plex/internal/ray"
 	"gorm.io/gorm"
 )
 
@@ -58,6 +64,59 @@ func GetJobHandler(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
+func CancelJobHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			utils.SendJSONError(w, "Only POST and DELETE methods are supported", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+		if !ok {
+			utils.SendJSONError(w, "User not found in context", http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		jobID, err := strconv.Atoi(params["jobID"])
+		if err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Job ID (%v) could not be converted to int", params["jobID"]), http.StatusNotFound)
+			return
+		}
+
+		var job models.Job
+		if result := db.First(&job, jobID); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Job not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Job: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if job.WalletAddress != user.WalletAddress && !user.Admin {
+			utils.SendJSONError(w, "Job not found or not authorized", http.StatusNotFound)
+			return
+		}
+
+		cancelledJob, err := utils.CancelJob(job.ID, db)
+		if err != nil {
+			if errors.Is(err, utils.ErrJobNotCancellable) {
+				utils.SendJSONError(w, fmt.Sprintf("Job %v is already %v", job.ID, job.JobStatus), http.StatusConflict)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error cancelling Job: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cancelledJob); err != nil {
+			utils.SendJSONError(w, "Error encoding Job to JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 type JobSummary struct {
 	Count         int     `json:"count"`
 	TotalCpu      float64 `json:"totalCpu"`
@@ -66,22 +125,27 @@ type JobSummary struct {
 }
 
 type QueueSummary struct {
-	Queued  JobSummary `json:"queued"`
-	Pending JobSummary `json:"pending"`
-	Running JobSummary `json:"running"`
+	Queued     JobSummary `json:"queued"`
+	Processing JobSummary `json:"processing"`
+	Pending    JobSummary `json:"pending"`
+	Running    JobSummary `json:"running"`
 }
 
 type Summary struct {
-	Ray QueueSummary `json:"ray"`
+	Ray      QueueSummary           `json:"ray"`
+	Capacity *utils.ClusterCapacity `json:"capacity,omitempty"`
 }
 
 type AggregatedData struct {
-	JobStatus models.JobState `gorm:"column:job_status"`
-	Count     int
+	JobStatus     models.JobState `gorm:"column:job_status"`
+	Count         int
+	TotalCpu      float64 `gorm:"column:total_cpu"`
+	TotalMemoryGb int     `gorm:"column:total_memory_gb"`
+	TotalGpu      int     `gorm:"column:total_gpu"`
 	// JobType   models.JobType `gorm:"column:job_type"`
 }
 
-func GetJobsQueueSummaryHandler(db *gorm.DB) http.HandlerFunc {
+func GetJobsQueueSummaryHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var summary Summary
 		var aggregatedResults []AggregatedData
@@ -90,11 +154,13 @@ func GetJobsQueueSummaryHandler(db *gorm.DB) http.HandlerFunc {
 		// add job type here to group by jobTypeJob and jobTypeService when incorporating ray jobs
 		db = db.Debug()
 		result := db.Table("jobs").
-			Select("jobs.job_status, count(*) as count").
+			Select("jobs.job_status, count(*) as count, " +
+				"COALESCE(SUM(models.cpu), 0) as total_cpu, " +
+				"COALESCE(SUM(models.memory_gb), 0) as total_memory_gb, " +
+				"COALESCE(SUM(models.gpu), 0) as total_gpu").
 			Joins("left join models on models.id = jobs.model_id").
 			Group("jobs.job_status").
 			Find(&aggregatedResults)
-		fmt.Printf("Aggregated Results: %+v\n", aggregatedResults)
 
 		if result.Error != nil {
 			http.Error(w, fmt.Sprintf("Error Querying Job Table (%v)", result.Error), http.StatusInternalServerError)
@@ -104,7 +170,10 @@ func GetJobsQueueSummaryHandler(db *gorm.DB) http.HandlerFunc {
 		// Compile results into summary
 		for _, data := range aggregatedResults {
 			jobSummary := JobSummary{
-				Count: data.Count,
+				Count:         data.Count,
+				TotalCpu:      data.TotalCpu,
+				TotalMemoryGb: data.TotalMemoryGb,
+				TotalGpu:      data.TotalGpu,
 			}
 
 			if data.JobStatus == models.JobStatePending {
@@ -113,16 +182,218 @@ func GetJobsQueueSummaryHandler(db *gorm.DB) http.HandlerFunc {
 				summary.Ray.Running = jobSummary
 			} else if data.JobStatus == models.JobStateQueued {
 				summary.Ray.Queued = jobSummary
+			} else if data.JobStatus == models.JobStateProcessing {
+				summary.Ray.Processing = jobSummary
 			}
 
 		}
 
+		if capacity := utils.ClusterCapacityFromConfig(cfg.Queue); capacity.IsLimited() {
+			summary.Capacity = &capacity
+		}
+
 		// Set content type and encode summary to JSON
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(summary)
 	}
 }
 
-func GetWorkerSummaryHandler(w http.ResponseWriter, r *http.Request) {
-	utils.GetWorkerSummary(w, r)
+// GetWorkerSummaryHandler lists the queue workers of all gateway and worker
+// processes that sent a heartbeat recently.
+func GetWorkerSummaryHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var workers []models.QueueWorker
+		cutoff := time.Now().UTC().Add(-3 * utils.WorkerHeartbeatInterval())
+		result := db.Where("last_heartbeat_at >= ?", cutoff).Order("id ASC").Find(&workers)
+		if result.Error != nil {
+			http.Error(w, fmt.Sprintf("Error Querying Worker Table (%v)", result.Error), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workers)
+	}
+}
+
+// JobCallbackHandler is called by a job when it finishes, so its results are
+// picked up right away instead of on the next monitoring tick. It is
+// authenticated with the job's JOB_CALLBACK_SIGNATURE, sent in the
+// X-Callback-Signature header.
+func JobCallbackHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ray.CallbacksEnabled() {
+			utils.SendJSONError(w, "Job callbacks are not enabled", http.StatusNotFound)
+			return
+		}
+
+		rayJobID := mux.Vars(r)["rayJobID"]
+		signature := r.Header.Get(ray.JobCallbackSignatureHeader)
+		if !hmac.Equal([]byte(signature), []byte(ray.JobCallbackToken(rayJobID))) {
+			utils.SendJSONError(w, "Invalid callback signature", http.StatusUnauthorized)
+			return
+		}
+
+		var job models.Job
+		if result := db.Where("ray_job_id = ?", rayJobID).First(&job); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Job not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Job: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("Received completion callback", "job_id", job.ID, "ray_job_id", rayJobID)
+		utils.RequestJobStatusCheck()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+const jobLogsFollowInterval = 2 * time.Second
+
+// GetJobLogsHandler returns the logs of a job from its backend as plain text.
+// ?tail=N limits the response to the last N lines, ?follow=true keeps the
+// connection open and streams new output until the job finishes.
+func GetJobLogsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+		if !ok {
+			utils.SendJSONError(w, "User not found in context", http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		jobID, err := strconv.Atoi(params["jobID"])
+		if err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Job ID (%v) could not be converted to int", params["jobID"]), http.StatusNotFound)
+			return
+		}
+
+		tail := 0
+		if tailParam := r.URL.Query().Get("tail"); tailParam != "" {
+			tail, err = strconv.Atoi(tailParam)
+			if err != nil || tail < 0 {
+				utils.SendJSONError(w, "tail must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+		follow := r.URL.Query().Get("follow") == "true"
+
+		var job models.Job
+		if result := db.Preload("Model").First(&job, jobID); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				utils.SendJSONError(w, "Job not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Job: %v", result.Error), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if job.WalletAddress != user.WalletAddress && !user.Admin {
+			utils.SendJSONError(w, "Job not found or not authorized", http.StatusNotFound)
+			return
+		}
+
+		if job.RayJobID == "" {
+			utils.SendJSONError(w, "Job has not been submitted yet", http.StatusNotFound)
+			return
+		}
+
+		backend := utils.GetJobBackend(job.Model)
+		logs, err := backend.Logs(&job)
+		if err != nil {
+			if errors.Is(err, utils.ErrBackendJobNotFound) {
+				utils.SendJSONError(w, "Logs for Job not found", http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching Job logs: %v", err), http.StatusBadGateway)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !follow {
+			fmt.Fprint(w, utils.TailLines(logs, tail))
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			utils.SendJSONError(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		fmt.Fprint(w, utils.TailLines(logs, tail))
+		flusher.Flush()
+
+		sent := len(logs)
+		if follower, ok := backend.(utils.LogFollower); ok {
+			followJobLogs(w, r, flusher, follower, &job, sent)
+			return
+		}
+
+		// Other backends are polled until the job finished
+		ticker := time.NewTicker(jobLogsFollowInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-serverShutdown(r):
+				return
+			case <-ticker.C:
+			}
+
+			// Read the state before the logs, so the last output is not missed
+			var current models.Job
+			if err := db.Select("job_status").First(&current, job.ID).Error; err != nil {
+				return
+			}
+			logs, err := backend.Logs(&job)
+			if err != nil {
+				return
+			}
+			if len(logs) < sent {
+				// The logs were reset, e.g. because the job was retried
+				sent = 0
+			}
+			fmt.Fprint(w, logs[sent:])
+			flusher.Flush()
+			sent = len(logs)
+
+			switch current.JobStatus {
+			case models.JobStateStopped, models.JobStateSucceeded, models.JobStateFailed:
+				return
+			}
+		}
+	}
+}
+
+// followJobLogs streams the logs of a job from a backend that pushes them as
+// they are written. The first sent bytes, which the client already has, are
+// skipped.
+func followJobLogs(w http.ResponseWriter, r *http.Request, flusher http.Flusher, follower utils.LogFollower, job *models.Job, sent int) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-serverShutdown(r):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := follower.FollowLogs(ctx, job, func(chunk string) error {
+		if sent >= len(chunk) {
+			sent -= len(chunk)
+			return nil
+		}
+		_, err := fmt.Fprint(w, chunk[sent:])
+		sent = 0
+		flusher.Flush()
+		return err
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Error following job logs", "job_id", job.ID, "error", err)
+	}
 }
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// MetricsHandler serves the Prometheus metrics. It is not part of the API
+// router and is only served on the internal metrics address.
+func MetricsHandler(db *gorm.DB) http.HandlerFunc {
+	promHandler := promhttp.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A failed refresh should not hide the other metrics, the queue
+		// gauges keep their last values
+		if err := utils.UpdateQueueMetrics(db); err != nil {
+			logging.FromContext(r.Context()).Error("Error updating queue metrics", "error", err)
+		}
+		promHandler.ServeHTTP(w, r)
+	}
+}
@@ -3,25 +3,27 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
 	"github.com/labdao/plex/internal/ipwl"
+	"github.com/labdao/plex/internal/logging"
 	"github.com/labdao/plex/internal/s3"
 	"gorm.io/gorm"
 )
 
-func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
+func AddModelHandler(db *gorm.DB, s3c *s3.S3Client, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Received request at /add-model")
+		logger := logging.FromContext(r.Context())
 
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -31,7 +33,7 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
-		log.Println("Request body: ", string(body))
+		logger.Debug("Request body", "body", string(body))
 
 		var modelRequest struct {
 			ModelJson json.RawMessage `json:"modelJson"`
@@ -69,12 +71,30 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			}
 		}
 
-		// Unmarshal the model from the model JSON
-		var model ipwl.Model
-		err = json.Unmarshal(modelRequest.ModelJson, &model)
+		// Unmarshal the model from the model JSON and check it, see ipwl.ModelSchema
+		model, err := ipwl.ParseModel(modelRequest.ModelJson)
 		if err != nil {
-			// http.Error(w, fmt.Sprintf("Invalid modelJson format: %v", err), http.StatusBadRequest)
-			utils.SendJSONError(w, fmt.Sprintf("Invalid modelJson format: %v", err), http.StatusBadRequest)
+			var validationErrors ipwl.ValidationErrors
+			if errors.As(err, &validationErrors) {
+				logger.Info("Rejected invalid model manifest", "model_name", model.Name, "errors", len(validationErrors))
+				utils.SendJSONErrorWithDetails(w, "Invalid model manifest", validationErrors, http.StatusBadRequest)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Invalid modelJson format: %v", err), http.StatusBadRequest)
+			}
+			return
+		}
+
+		// Published versions are immutable, changes need a new version
+		if model.Version == "" {
+			model.Version = ipwl.DefaultModelVersion
+		}
+		var published int64
+		if err := db.Model(&models.Model{}).Where("name = ? AND version = ?", model.Name, model.Version).Count(&published).Error; err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Error checking model versions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if published > 0 {
+			utils.SendJSONError(w, versionExistsMessage(model), http.StatusConflict)
 			return
 		}
 
@@ -93,18 +113,14 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			return
 		}
 
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			utils.SendJSONError(w, "Missing BUCKET_NAME environment variable", http.StatusInternalServerError)
-			return
-		}
+		bucketName := cfg.Bucket.Name
 
 		hash, err := utils.GenerateFileHash(tempFile.Name())
 		if err != nil {
 			utils.SendJSONError(w, fmt.Sprintf("Error hashing file: %v", err), http.StatusInternalServerError)
 			return
 		}
-		fmt.Println("Hash of the model manifest", tempFile.Name(), " file: ", hash)
+		logger.Debug("Generated model manifest hash", "file", tempFile.Name(), "hash", hash)
 		defer os.Remove(tempFile.Name())
 
 		objectKey := hash + "/" + tempFile.Name()
@@ -139,6 +155,13 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 		} else {
 			jobType = models.JobTypeJob
 		}
+
+		var queueType models.QueueType
+		if model.ModelType == ipwl.ModelTypeExec {
+			queueType = models.QueueTypeExec
+		} else {
+			queueType = models.QueueTypeRay
+		}
 		// Start transaction
 		tx := db.Begin()
 
@@ -162,6 +185,7 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 		modelEntry := models.Model{
 			WalletAddress:  user.WalletAddress,
 			Name:           model.Name,
+			Version:        model.Version,
 			ModelJson:      modelJSON,
 			CreatedAt:      time.Now().UTC(),
 			Display:        display,
@@ -172,6 +196,10 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			ComputeCost:    model.ComputeCost,
 			S3URI:          s3_uri,
 			JobType:        jobType,
+			QueueType:      queueType,
+			CPU:            model.Resources.CPU,
+			MemoryGb:       model.Resources.MemoryGb,
+			GPU:            model.Resources.GPU,
 		}
 
 		result := tx.Create(&modelEntry)
@@ -179,7 +207,7 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 			tx.Rollback()
 			if utils.IsDuplicateKeyError(result.Error) {
 				// http.Error(w, "A model with the same ID already exists", http.StatusConflict)
-				utils.SendJSONError(w, "This model already exists", http.StatusConflict)
+				utils.SendJSONError(w, versionExistsMessage(model), http.StatusConflict)
 			} else {
 				// http.Error(w, fmt.Sprintf("Error creating model entity: %v", result.Error), http.StatusInternalServerError)
 				utils.SendJSONError(w, fmt.Sprintf("Error creating model entity: %v", result.Error), http.StatusInternalServerError)
@@ -198,6 +226,10 @@ func AddModelHandler(db *gorm.DB, s3c *s3.S3Client) http.HandlerFunc {
 	}
 }
 
+func versionExistsMessage(model ipwl.Model) string {
+	return fmt.Sprintf("Version %s of model %s is already published, published versions cannot be changed. Publish a new version instead.", model.Version, model.Name)
+}
+
 func UpdateModelHandler(db *gorm.DB) http.HandlerFunc {
 	acceptedTaskCategories := map[string]bool{
 		"protein-binder-design": true,
@@ -220,12 +252,19 @@ func UpdateModelHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// Only catalog flags can change, what a published version runs and
+		// costs is fixed so that experiments stay reproducible
 		var requestData struct {
-			TaskCategory   *string `json:"taskCategory,omitempty"`
-			Display        *bool   `json:"display,omitempty"`
-			DefaultModel   *bool   `json:"defaultModel,omitempty"`
-			MaxRunningTime *int    `json:"maxRunningTime,omitempty"`
-			ComputeCost    *int    `json:"computeCost,omitempty"`
+			TaskCategory       *string  `json:"taskCategory,omitempty"`
+			Display            *bool    `json:"display,omitempty"`
+			DefaultModel       *bool    `json:"defaultModel,omitempty"`
+			Deprecated         *bool    `json:"deprecated,omitempty"`
+			DeprecationMessage *string  `json:"deprecationMessage,omitempty"`
+			MaxRunningTime     *int     `json:"maxRunningTime,omitempty"`
+			ComputeCost        *int     `json:"computeCost,omitempty"`
+			CPU                *float64 `json:"cpu,omitempty"`
+			MemoryGb           *int     `json:"memoryGb,omitempty"`
+			GPU                *int     `json:"gpu,omitempty"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
@@ -240,6 +279,11 @@ func UpdateModelHandler(db *gorm.DB) http.HandlerFunc {
 			}
 		}
 
+		if requestData.MaxRunningTime != nil || requestData.ComputeCost != nil || requestData.CPU != nil || requestData.MemoryGb != nil || requestData.GPU != nil {
+			utils.SendJSONError(w, "The running time, compute cost and resources of a published model version cannot be changed. Publish a new version instead.", http.StatusConflict)
+			return
+		}
+
 		tx := db.Begin()
 
 		updateData := make(map[string]interface{})
@@ -252,11 +296,11 @@ func UpdateModelHandler(db *gorm.DB) http.HandlerFunc {
 		if requestData.DefaultModel != nil {
 			updateData["default_model"] = *requestData.DefaultModel
 		}
-		if requestData.MaxRunningTime != nil {
-			updateData["max_running_time"] = *requestData.MaxRunningTime
+		if requestData.Deprecated != nil {
+			updateData["deprecated"] = *requestData.Deprecated
 		}
-		if requestData.ComputeCost != nil {
-			updateData["compute_cost"] = *requestData.ComputeCost
+		if requestData.DeprecationMessage != nil {
+			updateData["deprecation_message"] = *requestData.DeprecationMessage
 		}
 
 		if len(updateData) == 0 {
@@ -341,6 +385,15 @@ func ListModelsHandler(db *gorm.DB) http.HandlerFunc {
 			query = query.Where("name = ?", name)
 		}
 
+		if version := r.URL.Query().Get("version"); version != "" {
+			query = query.Where("version = ?", version)
+		}
+
+		// Deprecated versions are only listed on request
+		if r.URL.Query().Get("include_deprecated") != "true" {
+			query = query.Where("deprecated = ?", false)
+		}
+
 		if walletAddress := r.URL.Query().Get("wallet_address"); walletAddress != "" {
 			query = query.Where("wallet_address = ?", walletAddress)
 		}
@@ -354,6 +407,9 @@ func ListModelsHandler(db *gorm.DB) http.HandlerFunc {
 			http.Error(w, fmt.Sprintf("Error fetching models: %v", result.Error), http.StatusInternalServerError)
 			return
 		}
+		if r.URL.Query().Get("latest") == "true" {
+			models = utils.LatestModelVersions(models)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(models); err != nil {
@@ -362,3 +418,60 @@ func ListModelsHandler(db *gorm.DB) http.HandlerFunc {
 		}
 	}
 }
+
+// ListModelVersionsHandler lists all versions of a model, the newest first,
+// including deprecated ones.
+func ListModelVersionsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+
+		var versions []models.Model
+		if err := db.Where("name = ?", name).Find(&versions).Error; err != nil {
+			utils.SendJSONError(w, fmt.Sprintf("Error fetching model versions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(versions) == 0 {
+			utils.SendJSONError(w, "Model not found", http.StatusNotFound)
+			return
+		}
+		utils.SortModelVersions(versions)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(versions); err != nil {
+			utils.SendJSONError(w, "Error encoding model versions to JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// GetModelVersionHandler returns one version of a model, where "latest" is
+// the newest version that is not deprecated.
+func GetModelVersionHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		model, err := utils.ResolveModelVersion(db, vars["name"], vars["version"])
+		if err != nil {
+			if errors.Is(err, utils.ErrModelVersionNotFound) {
+				utils.SendJSONError(w, err.Error(), http.StatusNotFound)
+			} else {
+				utils.SendJSONError(w, fmt.Sprintf("Error fetching model version: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(model); err != nil {
+			utils.SendJSONError(w, "Error encoding model to JSON", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// GetModelSchemaHandler serves the JSON Schema model manifests are validated
+// against.
+func GetModelSchemaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(ipwl.ModelSchema)
+	}
+}
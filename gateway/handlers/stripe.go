@@ -5,13 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
 	"github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/billing/meter"
 	"github.com/stripe/stripe-go/v78/billing/metereventsummary"
@@ -25,12 +27,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// setupStripeClient checks that the Stripe API key was configured at
+// startup, see utils.Configure.
 func setupStripeClient() error {
-	apiKey := os.Getenv("STRIPE_SECRET_KEY")
-	if apiKey == "" {
-		return errors.New("STRIPE_SECRET_KEY environment variable not set")
+	if stripe.Key == "" {
+		return errors.New("STRIPE_SECRET_KEY is not configured")
 	}
-	stripe.Key = apiKey
 	return nil
 }
 
@@ -51,15 +53,14 @@ func createStripeCustomer(walletAddress string) (string, error) {
 	return customer.ID, nil
 }
 
-func createCheckoutSession(stripeUserID, walletAddress, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
+func createCheckoutSession(stripeUserID, walletAddress, priceID, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
 	err := setupStripeClient()
 	if err != nil {
 		return nil, err
 	}
 
-	priceID := os.Getenv("STRIPE_PRICE_ID")
 	if priceID == "" {
-		return nil, errors.New("STRIPE_PRICE_ID environment variable not set")
+		return nil, errors.New("STRIPE_PRICE_ID is not configured")
 	}
 
 	params := &stripe.CheckoutSessionParams{
@@ -91,7 +92,7 @@ func createCheckoutSession(stripeUserID, walletAddress, successURL, cancelURL st
 	return session, nil
 }
 
-func StripeCreateCheckoutSessionHandler(db *gorm.DB) http.HandlerFunc {
+func StripeCreateCheckoutSessionHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctxUser := r.Context().Value(middleware.UserContextKey)
 		user, ok := ctxUser.(*models.User)
@@ -118,35 +119,36 @@ func StripeCreateCheckoutSessionHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		session, err := createCheckoutSession(user.StripeUserID, user.WalletAddress, requestBody.SuccessURL, requestBody.CancelURL)
+		session, err := createCheckoutSession(user.StripeUserID, user.WalletAddress, cfg.Stripe.PriceID, requestBody.SuccessURL, requestBody.CancelURL)
 		if err != nil {
 			utils.SendJSONError(w, fmt.Sprintf("Error creating checkout session: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		fmt.Println("Checkout URL:", session.URL)
+		logging.FromContext(r.Context()).Info("Created checkout session", "session_id", session.ID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"url": session.URL})
 	}
 }
 
-func StripeFulfillmentHandler(db *gorm.DB) http.HandlerFunc {
+func StripeFulfillmentHandler(db *gorm.DB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
 		payload, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading request body: %v\n", err)
+			logger.Error("Error reading request body", "error", err)
 			w.WriteHeader(http.StatusServiceUnavailable)
 			return
 		}
 
-		endpointSecret := os.Getenv("STRIPE_WEBHOOK_SECRET_KEY")
+		endpointSecret := cfg.Stripe.WebhookSecret
 
 		event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), endpointSecret, webhook.ConstructEventOptions{
 			IgnoreAPIVersionMismatch: true,
 		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error verifying webhook signature: %v\n", err)
+			logger.Warn("Error verifying webhook signature", "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -156,14 +158,14 @@ func StripeFulfillmentHandler(db *gorm.DB) http.HandlerFunc {
 			var subscription stripe.Subscription
 			err := json.Unmarshal(event.Data.Raw, &subscription)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error parsing subscription: %v\n", err)
+				logger.Error("Error parsing subscription", "error", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 
 			walletAddress, ok := subscription.Metadata["Wallet Address"]
 			if !ok {
-				fmt.Fprintf(os.Stderr, "Wallet Address not found in subscription metadata\n")
+				logger.Error("Wallet Address not found in subscription metadata", "subscription_id", subscription.ID)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
@@ -172,10 +174,10 @@ func StripeFulfillmentHandler(db *gorm.DB) http.HandlerFunc {
 			result := db.Where("wallet_address ILIKE ?", walletAddress).First(&user)
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					fmt.Fprintf(os.Stderr, "User with wallet address %s not found\n", walletAddress)
+					logger.Error("User not found", "wallet_address", walletAddress)
 					w.WriteHeader(http.StatusNotFound)
 				} else {
-					fmt.Fprintf(os.Stderr, "Error querying user: %v\n", result.Error)
+					logger.Error("Error querying user", "error", result.Error)
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 				return
@@ -189,12 +191,12 @@ func StripeFulfillmentHandler(db *gorm.DB) http.HandlerFunc {
 			user.SubscriptionID = &subscription.ID
 			result = db.Save(&user)
 			if result.Error != nil {
-				fmt.Fprintf(os.Stderr, "Error updating user subscription status: %v\n", result.Error)
+				logger.Error("Error updating user subscription status", "error", result.Error)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 
-			fmt.Printf("Subscription %s for user %s updated to %s\n", subscription.ID, walletAddress, user.SubscriptionStatus)
+			logger.Info("Subscription updated", "subscription_id", subscription.ID, "wallet_address", walletAddress, "status", user.SubscriptionStatus)
 
 		// PR#1010 this might not be relevant anymore as we are not providing a trial period
 		case "customer.subscription.trial_will_end":
@@ -207,14 +209,14 @@ func StripeFulfillmentHandler(db *gorm.DB) http.HandlerFunc {
 			// You might want to update the user's payment status or send notifications
 
 		default:
-			fmt.Fprintf(os.Stderr, "Unhandled event type: %s\n", event.Type)
+			logger.Warn("Unhandled event type", "event_type", event.Type)
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func StripeGetPlanDetailsHandler() http.HandlerFunc {
+func StripeGetPlanDetailsHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := setupStripeClient()
 		if err != nil {
@@ -222,10 +224,9 @@ func StripeGetPlanDetailsHandler() http.HandlerFunc {
 			return
 		}
 
-		// Fetch the default price ID from environment variables
-		priceID := os.Getenv("STRIPE_PRICE_ID")
+		priceID := cfg.Stripe.PriceID
 		if priceID == "" {
-			utils.SendJSONError(w, "STRIPE_PRICE_ID environment variable not set", http.StatusInternalServerError)
+			utils.SendJSONError(w, "STRIPE_PRICE_ID is not configured", http.StatusInternalServerError)
 			return
 		}
 
@@ -267,7 +268,7 @@ func StripeGetPlanDetailsHandler() http.HandlerFunc {
 			// Handle non-tiered flat pricing
 			flatFee = float64(priceDetails.UnitAmount) / 100
 		} else {
-			fmt.Println("Tiers or flat pricing not configured properly")
+			logging.FromContext(r.Context()).Warn("Tiers or flat pricing not configured properly", "price_id", priceDetails.ID)
 		}
 
 		// Prepare the response
@@ -361,7 +362,7 @@ func StripeGetSubscriptionHandler(db *gorm.DB) http.HandlerFunc {
 			// Handle non-tiered flat pricing
 			flatFee = float64(priceDetails.UnitAmount) / 100
 		} else {
-			fmt.Println("Tiers or flat pricing not configured properly")
+			logging.FromContext(r.Context()).Warn("Tiers or flat pricing not configured properly", "price_id", priceDetails.ID)
 		}
 
 		// Fetch used credits
@@ -408,7 +409,8 @@ func fetchUsedCredits(stripeCustomerID string, eventName string, subscription *s
 	endTime := time.Unix(subscription.CurrentPeriodEnd, 0)
 	endTime = time.Date(endTime.Year(), endTime.Month(), endTime.Day(), 0, 0, 0, 0, time.UTC)
 
-	fmt.Printf("Fetching usage records from %v to %v\n", startTime, endTime)
+	logger := slog.Default().With("stripe_customer_id", stripeCustomerID)
+	logger.Debug("Fetching usage records", "start", startTime, "end", endTime)
 
 	params := &stripe.BillingMeterEventSummaryListParams{
 		Customer:            stripe.String(stripeCustomerID),
@@ -424,10 +426,10 @@ func fetchUsedCredits(stripeCustomerID string, eventName string, subscription *s
 	for iterator.Next() {
 		summary := iterator.BillingMeterEventSummary()
 		if summary == nil {
-			fmt.Println("Empty summary, skipping...")
+			logger.Debug("Empty summary, skipping")
 			continue
 		}
-		fmt.Printf("Aggregated value on %v: %v\n", time.Unix(summary.StartTime, 0), summary.AggregatedValue)
+		logger.Debug("Aggregated usage", "day", time.Unix(summary.StartTime, 0), "value", summary.AggregatedValue)
 		usedCredits += int(summary.AggregatedValue)
 	}
 
@@ -3,12 +3,12 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
 	"gorm.io/gorm"
 )
 
@@ -33,7 +33,7 @@ func ListTransactionsHandler(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		log.Println("Fetched Transactions from DB: ", transactions)
+		logging.FromContext(r.Context()).Debug("Fetched Transactions from DB", "count", len(transactions))
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(transactions); err != nil {
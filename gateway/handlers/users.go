@@ -3,12 +3,14 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/labdao/plex/gateway/middleware"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
 	"github.com/labdao/plex/internal/web3"
 
 	"gorm.io/gorm"
@@ -16,9 +18,9 @@ import (
 
 func AddUserHandler(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
 		if err := utils.CheckRequestMethod(r, http.MethodPost); err != nil {
 			utils.SendJSONError(w, "Only POST method is supported", http.StatusBadRequest)
-			fmt.Println("Received non-POST request for /user endpoint.")
 			return
 		}
 
@@ -28,23 +30,23 @@ func AddUserHandler(db *gorm.DB) http.HandlerFunc {
 
 		if err := utils.ReadRequestBody(r, &requestData); err != nil {
 			utils.SendJSONError(w, "Error parsing request body", http.StatusBadRequest)
-			fmt.Println("Error decoding request body:", err)
+			logger.Warn("Error decoding request body", "error", err)
 			return
 		}
 
-		fmt.Printf("Received request to create/get user: WalletAddress: %s\n", requestData.WalletAddress)
+		logger = logger.With("wallet_address", requestData.WalletAddress)
 
 		isValidAddress := web3.IsValidEthereumAddress(requestData.WalletAddress)
 		if !isValidAddress {
 			utils.SendJSONError(w, "Invalid wallet address", http.StatusBadRequest)
-			fmt.Println("Invalid wallet address:", requestData.WalletAddress)
+			logger.Warn("Invalid wallet address")
 			return
 		}
 
 		did, err := middleware.GetUserDIDFromRequest(r, db)
 		if err != nil {
 			utils.SendJSONError(w, "Error getting user DID from request", http.StatusInternalServerError)
-			fmt.Println("Error getting user DID from request:", err)
+			logger.Error("Error getting user DID from request", "error", err)
 			return
 		}
 
@@ -56,7 +58,7 @@ func AddUserHandler(db *gorm.DB) http.HandlerFunc {
 			stripeUserID, err := createStripeCustomer(requestData.WalletAddress)
 			if err != nil {
 				utils.SendJSONError(w, fmt.Sprintf("Error creating Stripe customer: %v", err), http.StatusInternalServerError)
-				fmt.Println("Error creating Stripe customer:", err)
+				logger.Error("Error creating Stripe customer", "error", err)
 				return
 			}
 
@@ -70,14 +72,14 @@ func AddUserHandler(db *gorm.DB) http.HandlerFunc {
 			}
 			if result := db.Create(&newUser); result.Error != nil {
 				utils.SendJSONError(w, fmt.Sprintf("Error creating user: %v", result.Error), http.StatusInternalServerError)
-				fmt.Println("Error creating user in database:", result.Error)
+				logger.Error("Error creating user in database", "error", result.Error)
 				return
 			}
-			fmt.Printf("Successfully created user with WalletAddress: %s and StripeUserID: %s\n", newUser.WalletAddress, newUser.StripeUserID)
+			logger.Info("Created user", "stripe_customer_id", newUser.StripeUserID)
 			user = newUser // Set user to newUser for consistent response
 		} else if err != nil {
 			utils.SendJSONError(w, "Database error", http.StatusInternalServerError)
-			fmt.Println("Database error:", err)
+			logger.Error("Database error", "error", err)
 			return
 		} else {
 			// User already exists, update DID if necessary
@@ -85,12 +87,12 @@ func AddUserHandler(db *gorm.DB) http.HandlerFunc {
 				user.DID = did
 				if err := db.Save(&user).Error; err != nil {
 					utils.SendJSONError(w, fmt.Sprintf("Error updating user: %v", err), http.StatusInternalServerError)
-					fmt.Println("Error updating user in database:", err)
+					logger.Error("Error updating user in database", "error", err)
 					return
 				}
-				fmt.Printf("Successfully updated user with WalletAddress: %s with new DID\n", user.WalletAddress)
+				logger.Info("Updated user with new DID")
 			} else {
-				fmt.Printf("User already exists with WalletAddress: %s and has a DID\n", user.WalletAddress)
+				logger.Debug("User already exists and has a DID")
 			}
 		}
 
@@ -157,12 +159,10 @@ func UpdateUserTier(db *gorm.DB, walletAddress string, threshold int) error {
 		user.Tier = models.TierPaid
 
 		if err := db.Save(&user).Error; err != nil {
-			fmt.Printf("Error updating tier for user with WalletAddress: %s: %v\n", walletAddress, err)
+			slog.Error("Error updating user tier", "wallet_address", walletAddress, "error", err)
 			return err
 		}
-		fmt.Printf("Successfully updated tier for user with WalletAddress: %s\n", walletAddress)
-	} else {
-		fmt.Printf("No need to update tier for user with WalletAddress: %s\n", walletAddress)
+		slog.Info("Updated user tier", "wallet_address", walletAddress, "tier", user.Tier)
 	}
 
 	return nil
@@ -0,0 +1,29 @@
+package gateway
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/labdao/plex/gateway/handlers"
+	"gorm.io/gorm"
+)
+
+// serveMetrics serves /metrics on addr, a listener of its own that is kept
+// off the public API. It returns nil without serving anything if addr is
+// empty.
+func serveMetrics(addr string, db *gorm.DB) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handlers.MetricsHandler(db))
+	metricsServer := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Metrics server stopped", "error", err)
+		}
+	}()
+	return metricsServer
+}
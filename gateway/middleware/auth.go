@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
 	"gorm.io/gorm"
 )
 
@@ -84,7 +86,7 @@ func keyFunc(token *jwt.Token) (interface{}, error) {
 func SetupConfig(appID string, publicKey string) (string, string) {
 	appId = appID
 	verificationKey = publicKey
-	fmt.Printf("Config setup. Verification Key: %v\n App ID: %v\n", verificationKey, appId)
+	slog.Info("Privy config set up", "app_id", appId, "verification_key_set", verificationKey != "")
 
 	return appId, verificationKey
 }
@@ -120,8 +122,6 @@ func GetUserDIDFromRequest(r *http.Request, db *gorm.DB) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return "", errors.New("Missing Authorization header")
-	} else {
-		fmt.Printf("Auth header: %v\n", authHeader)
 	}
 
 	splitToken := strings.Split(authHeader, "Bearer ")
@@ -130,22 +130,17 @@ func GetUserDIDFromRequest(r *http.Request, db *gorm.DB) (string, error) {
 	}
 
 	tokenString := splitToken[1]
-	fmt.Println("Token string:", tokenString)
 
 	token, err := jwt.ParseWithClaims(tokenString, &PrivyClaims{}, keyFunc)
 	if err != nil {
 		return "", fmt.Errorf("JWT signature is invalid: %v", err)
 	}
 
-	if claims, ok := token.Claims.(*jwt.MapClaims); ok {
-		fmt.Printf("Raw JWT claims: %+v\n", *claims)
-	}
-
 	privyClaim, ok := token.Claims.(*PrivyClaims)
 	if !ok || privyClaim.Valid() != nil {
 		return "", errors.New("JWT claims are invalid")
 	} else {
-		fmt.Printf("JWT claims are valid: %+v\n", privyClaim)
+		logging.FromContext(r.Context()).Debug("JWT claims are valid", "did", privyClaim.UserId)
 		return privyClaim.UserId, nil
 	}
 }
@@ -202,9 +197,10 @@ func GetWalletAddressFromAPIKey(apiKey string, db *gorm.DB) (string, error) {
 func AuthMiddleware(db *gorm.DB) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
+			logger := logging.FromContext(r.Context())
 			token, err := utils.ExtractAuthHeader(r)
 			if err != nil {
-				fmt.Println("Error extracting JWT from header:", err)
+				logger.Warn("Error extracting JWT from header", "error", err)
 				http.Error(w, "Invalid Authorization header", http.StatusUnauthorized)
 				return
 			}
@@ -213,20 +209,20 @@ func AuthMiddleware(db *gorm.DB) func(http.HandlerFunc) http.HandlerFunc {
 			if IsJWT(token) {
 				claims, err := ValidateJWT(token, db)
 				if err != nil {
-					fmt.Println("JWT validation error:", err)
+					logger.Warn("JWT validation error", "error", err)
 					http.Error(w, "Invalid JWT", http.StatusUnauthorized)
 					return
 				}
 				user, err = GetUserByDID(claims.UserId, db)
 				if err != nil {
-					fmt.Println("error fetching user from JWT DID:", err)
+					logger.Warn("Error fetching user from JWT DID", "error", err)
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
 				}
 			} else {
 				user, err = GetUserByAPIKey(token, db)
 				if err != nil {
-					fmt.Println("error fetching user from api key:", err)
+					logger.Warn("Error fetching user from API key", "error", err)
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
 				}
@@ -234,6 +230,7 @@ func AuthMiddleware(db *gorm.DB) func(http.HandlerFunc) http.HandlerFunc {
 
 			// Store the user model in the context so handlers can access user
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = logging.WithLogger(ctx, logger.With("wallet_address", user.WalletAddress))
 			r = r.WithContext(ctx)
 
 			next(w, r)
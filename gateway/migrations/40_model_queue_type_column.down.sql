@@ -0,0 +1 @@
+ALTER TABLE models DROP COLUMN IF EXISTS queue_type;
\ No newline at end of file
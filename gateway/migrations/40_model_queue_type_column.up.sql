@@ -0,0 +1 @@
+ALTER TABLE models ADD COLUMN IF NOT EXISTS queue_type VARCHAR(255) DEFAULT 'ray';
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_priority;
+ALTER TABLE jobs DROP COLUMN IF EXISTS priority;
\ No newline at end of file
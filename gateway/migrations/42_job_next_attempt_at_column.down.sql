@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_next_attempt_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS next_attempt_at;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS claimed_by;
\ No newline at end of file
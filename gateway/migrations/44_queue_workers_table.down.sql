@@ -0,0 +1 @@
+DROP TABLE IF EXISTS queue_workers;
\ No newline at end of file
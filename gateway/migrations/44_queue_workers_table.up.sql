@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS queue_workers (
+    id VARCHAR(255) PRIMARY KEY,
+    instance_id VARCHAR(255) NOT NULL,
+    hostname VARCHAR(255),
+    busy BOOLEAN NOT NULL DEFAULT FALSE,
+    current_job_id INT,
+    current_job VARCHAR(255),
+    started_at TIMESTAMP,
+    last_heartbeat_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_queue_workers_instance_id ON queue_workers(instance_id);
+CREATE INDEX IF NOT EXISTS idx_queue_workers_last_heartbeat_at ON queue_workers(last_heartbeat_at);
\ No newline at end of file
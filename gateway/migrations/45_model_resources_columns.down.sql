@@ -0,0 +1,3 @@
+ALTER TABLE models DROP COLUMN IF EXISTS gpu;
+ALTER TABLE models DROP COLUMN IF EXISTS memory_gb;
+ALTER TABLE models DROP COLUMN IF EXISTS cpu;
\ No newline at end of file
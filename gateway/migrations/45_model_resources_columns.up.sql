@@ -0,0 +1,3 @@
+ALTER TABLE models ADD COLUMN IF NOT EXISTS cpu FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE models ADD COLUMN IF NOT EXISTS memory_gb INT NOT NULL DEFAULT 0;
+ALTER TABLE models ADD COLUMN IF NOT EXISTS gpu INT NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS trace_parent VARCHAR(55) DEFAULT '';
\ No newline at end of file
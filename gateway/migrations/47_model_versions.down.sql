@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_models_name_version;
+ALTER TABLE models ADD CONSTRAINT models_name_key UNIQUE (name);
+
+ALTER TABLE models DROP COLUMN IF EXISTS deprecation_message;
+ALTER TABLE models DROP COLUMN IF EXISTS deprecated;
+ALTER TABLE models DROP COLUMN IF EXISTS version;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE models ADD COLUMN IF NOT EXISTS version VARCHAR(64) NOT NULL DEFAULT '1.0.0';
+ALTER TABLE models ADD COLUMN IF NOT EXISTS deprecated BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE models ADD COLUMN IF NOT EXISTS deprecation_message TEXT NOT NULL DEFAULT '';
+
+-- A model name now names a family of versions
+ALTER TABLE models DROP CONSTRAINT IF EXISTS models_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_models_name_version ON models (name, version);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS cancel_pending;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cancel_pending BOOLEAN NOT NULL DEFAULT false;
\ No newline at end of file
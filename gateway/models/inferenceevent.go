@@ -8,14 +8,16 @@ import (
 
 // event type can only be certain string values
 const (
-	EventTypeJobQueued     = "job_queued"
-	EventTypeJobProcessing = "job_processing"
-	EventTypeJobPending    = "job_pending"
-	EventTypeJobRunning    = "job_running"
-	EventTypeJobStopped    = "job_stopped"
-	EventTypeJobSucceeded  = "job_succeeded"
-	EventTypeJobFailed     = "job_failed"
-	EventTypeFileProcessed = "file_processed"
+	EventTypeJobQueued         = "job_queued"
+	EventTypeJobProcessing     = "job_processing"
+	EventTypeJobPending        = "job_pending"
+	EventTypeJobRunning        = "job_running"
+	EventTypeJobStopped        = "job_stopped"
+	EventTypeJobSucceeded      = "job_succeeded"
+	EventTypeJobFailed         = "job_failed"
+	EventTypeJobTimedOut       = "job_timed_out"
+	EventTypeJobRetryScheduled = "job_retry_scheduled"
+	EventTypeFileProcessed     = "file_processed"
 )
 
 // retry default 0?
@@ -21,7 +21,8 @@ const (
 type QueueType string
 
 const (
-	QueueTypeRay QueueType = "ray"
+	QueueTypeRay  QueueType = "ray"
+	QueueTypeExec QueueType = "exec"
 )
 
 type Job struct {
@@ -31,6 +32,9 @@ type Job struct {
 	CreatedAt      time.Time      `gorm:""`
 	StartedAt      time.Time      `gorm:""`
 	CompletedAt    time.Time      `gorm:""`
+	NextAttemptAt  time.Time      `gorm:"index"`
+	ClaimedBy      string         `gorm:"type:varchar(255);default:''"`
+	LeaseExpiresAt time.Time      `gorm:""`
 	LastModifiedAt time.Time      `gorm:"autoUpdateTime"`
 	ExperimentID   uint           `gorm:"type:int;not null;index"`
 	Experiment     Experiment     `gorm:"foreignKey:ExperimentID"`
@@ -39,9 +43,14 @@ type Job struct {
 	WalletAddress  string         `gorm:"type:varchar(255)"`
 	Public         bool           `gorm:"type:boolean;not null;default:false"`
 	RetryCount     int            `gorm:"type:int;not null;default:0"`
+	Priority       int            `gorm:"type:int;not null;default:0;index"`
 	Error          string         `gorm:"type:text;default:''"`
 	Inputs         datatypes.JSON `gorm:"type:json"`
 	InputFiles     []File         `gorm:"many2many:job_input_files;foreignKey:ID;joinForeignKey:job_id;References:ID;JoinReferences:file_id"`
 	OutputFiles    []File         `gorm:"many2many:job_output_files;foreignKey:ID;references:ID"`
 	JobType        JobType        `gorm:"type:varchar(255);default:'job'"`
+	TraceParent    string         `gorm:"type:varchar(55);default:''"`
+	// CancelPending is set while a stopped job still has to be cancelled
+	// in its backend.
+	CancelPending bool `gorm:"type:boolean;not null;default:false"`
 }
@@ -13,19 +13,30 @@ const (
 	JobTypeService JobType = "service"
 )
 
+// Model is one published version of a model, versions with the same Name
+// form a model family. Published versions are immutable apart from catalog
+// flags. Deprecated versions are hidden from listings and never resolved as
+// the latest version, but jobs pinned to them keep working.
 type Model struct {
-	ID               int            `gorm:"primaryKey;autoIncrement"`
-	Name             string         `gorm:"type:text;not null;unique"`
-	WalletAddress    string         `gorm:"type:varchar(42);not null"`
-	ModelJson        datatypes.JSON `gorm:"type:json"`
-	CreatedAt        time.Time      `gorm:"autoCreateTime"`
-	Display          bool           `gorm:"type:boolean;default:true"`
-	TaskCategory     string         `gorm:"type:text;default:'community-models'"`
-	DefaultModel     bool           `gorm:"type:boolean;default:false"`
-	MaxRunningTime   int            `gorm:"type:int;default:2700"`
-	ComputeCost      int            `gorm:"type:int;not null;default:0"`
-	RayEndpoint      string         `gorm:"type:varchar(255)"`
-	RayJobEntrypoint string         `gorm:"type:varchar(255)"`
-	S3URI            string         `gorm:"type:varchar(255)"`
-	JobType          JobType        `gorm:"type:text;default:'job'"`
+	ID                 int            `gorm:"primaryKey;autoIncrement"`
+	Name               string         `gorm:"type:text;not null;uniqueIndex:idx_models_name_version"`
+	Version            string         `gorm:"type:varchar(64);not null;default:'1.0.0';uniqueIndex:idx_models_name_version"`
+	WalletAddress      string         `gorm:"type:varchar(42);not null"`
+	ModelJson          datatypes.JSON `gorm:"type:json"`
+	CreatedAt          time.Time      `gorm:"autoCreateTime"`
+	Display            bool           `gorm:"type:boolean;default:true"`
+	TaskCategory       string         `gorm:"type:text;default:'community-models'"`
+	DefaultModel       bool           `gorm:"type:boolean;default:false"`
+	MaxRunningTime     int            `gorm:"type:int;default:2700"`
+	ComputeCost        int            `gorm:"type:int;not null;default:0"`
+	RayEndpoint        string         `gorm:"type:varchar(255)"`
+	RayJobEntrypoint   string         `gorm:"type:varchar(255)"`
+	S3URI              string         `gorm:"type:varchar(255)"`
+	JobType            JobType        `gorm:"type:text;default:'job'"`
+	QueueType          QueueType      `gorm:"type:varchar(255);default:'ray'"`
+	CPU                float64        `gorm:"column:cpu;type:float;not null;default:0"`
+	MemoryGb           int            `gorm:"type:int;not null;default:0"`
+	GPU                int            `gorm:"column:gpu;type:int;not null;default:0"`
+	Deprecated         bool           `gorm:"type:boolean;not null;default:false"`
+	DeprecationMessage string         `gorm:"type:text;not null;default:''"`
 }
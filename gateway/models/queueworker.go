@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// QueueWorker is a queue worker goroutine of a gateway or `plex worker`
+// process. Workers heartbeat their row, so the table reflects all replicas.
+type QueueWorker struct {
+	ID              string    `gorm:"primaryKey;type:varchar(255)" json:"id"`
+	InstanceID      string    `gorm:"type:varchar(255);not null;index" json:"instanceId"`
+	Hostname        string    `gorm:"type:varchar(255)" json:"hostname"`
+	Busy            bool      `gorm:"not null;default:false" json:"busy"`
+	CurrentJobID    *uint     `gorm:"type:int" json:"currentJobId"`
+	CurrentJob      *string   `gorm:"type:varchar(255)" json:"currentJob"`
+	StartedAt       time.Time `gorm:"" json:"startedAt"`
+	LastHeartbeatAt time.Time `gorm:"index" json:"lastHeartbeatAt"`
+}
@@ -1,22 +1,130 @@
 package server
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/labdao/plex/gateway/config"
 	"github.com/labdao/plex/gateway/handlers"
 	"github.com/labdao/plex/gateway/middleware"
+	"github.com/labdao/plex/internal/logging"
 	"github.com/labdao/plex/internal/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"gorm.io/gorm"
 )
 
+const requestIDHeader = "X-Request-ID"
+
+// loggingMiddleware gives every request an ID, taken from X-Request-ID if the
+// caller sent one, and a logger that carries it. The ID is echoed in the
+// response so users can reference it in bug reports.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println(r.Method, r.URL)
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+		r = r.WithContext(logging.WithLogger(r.Context(), logger))
+
+		start := time.Now()
+		recorder := recordStatus(w)
+		next.ServeHTTP(recorder, r)
+
+		route := routeTemplate(r)
+		level := slog.LevelInfo
+		if route == "/healthcheck" || route == "/metrics" {
+			// Probes and scrapes would drown out everything else
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "Request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// tracingMiddleware starts a server span for every request, named after its
+// route template and continuing the trace of the caller if it sent a
+// traceparent header.
+func tracingMiddleware(next http.Handler) http.Handler {
+	tagged := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", routeTemplate(r)))
 		next.ServeHTTP(w, r)
 	})
+	return otelhttp.NewHandler(tagged, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routeTemplate(r)
+		}),
+	)
+}
+
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "plex_http_request_duration_seconds",
+	Help:    "Latency of HTTP requests by route template.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+// statusRecorder remembers the status code of a response. It keeps Flush
+// working for the event and log streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func recordStatus(w http.ResponseWriter) *statusRecorder {
+	if recorder, ok := w.(*statusRecorder); ok {
+		return recorder
+	}
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsMiddleware records request latencies per route template, so that
+// /jobs/1 and /jobs/2 end up in the same series.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := recordStatus(w)
+		next.ServeHTTP(recorder, r)
+		httpRequestDuration.WithLabelValues(routeTemplate(r), r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
 }
 
 func createProtectedRouteHandler(db *gorm.DB) func(http.HandlerFunc) http.HandlerFunc {
@@ -31,9 +139,11 @@ func createAdminProtectedRouteHandler(db *gorm.DB) func(http.HandlerFunc) http.H
 	}
 }
 
-func NewServer(db *gorm.DB, s3c *s3.S3Client) *mux.Router {
+func NewServer(cfg *config.Config, db *gorm.DB, s3c *s3.S3Client) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
 	router.Use(loggingMiddleware)
+	router.Use(metricsMiddleware)
 
 	protected := createProtectedRouteHandler(db)
 	adminProtected := createAdminProtectedRouteHandler(db)
@@ -43,29 +153,37 @@ func NewServer(db *gorm.DB, s3c *s3.S3Client) *mux.Router {
 	router.HandleFunc("/user", handlers.AddUserHandler(db)).Methods("POST")
 	router.HandleFunc("/user", protected(handlers.GetUserHandler(db))).Methods("GET")
 
-	router.HandleFunc("/models", protected(adminProtected(handlers.AddModelHandler(db, s3c)))).Methods("POST")
+	router.HandleFunc("/models", protected(adminProtected(handlers.AddModelHandler(db, s3c, cfg)))).Methods("POST")
+	router.HandleFunc("/models/schema", handlers.GetModelSchemaHandler()).Methods("GET")
 	router.HandleFunc("/models/{id}", protected(handlers.GetModelHandler(db))).Methods("GET")
+	router.HandleFunc("/models/{name}/versions", protected(handlers.ListModelVersionsHandler(db))).Methods("GET")
+	router.HandleFunc("/models/{name}/versions/{version}", protected(handlers.GetModelVersionHandler(db))).Methods("GET")
 	router.HandleFunc("/models", protected(handlers.ListModelsHandler(db))).Methods("GET")
 	router.HandleFunc("/models/{id}", protected(adminProtected(handlers.UpdateModelHandler(db)))).Methods("PUT")
 
-	router.HandleFunc("/files", protected(handlers.AddFileHandler(db, s3c))).Methods("POST")
+	router.HandleFunc("/files", protected(handlers.AddFileHandler(db, s3c, cfg))).Methods("POST")
 	router.HandleFunc("/files/{id}", protected(handlers.GetFileHandler(db))).Methods("GET")
 	router.HandleFunc("/files/{id}", protected(handlers.UpdateFileHandler(db))).Methods("PUT")
 	router.HandleFunc("/files/{id}/download", protected(handlers.DownloadFileHandler(db, s3c))).Methods("GET")
 	router.HandleFunc("/files", protected(handlers.ListFilesHandler(db))).Methods("GET")
 
-	router.HandleFunc("/checkpoints/{experimentID}/get-data", protected(handlers.GetExperimentCheckpointDataHandler(db))).Methods("GET")
+	router.HandleFunc("/checkpoints/{experimentID}/get-data", protected(handlers.GetExperimentCheckpointDataHandler(db, cfg))).Methods("GET")
 
-	router.HandleFunc("/experiments", protected(handlers.AddExperimentHandler(db))).Methods("POST")
+	router.HandleFunc("/experiments", protected(handlers.AddExperimentHandler(db, cfg))).Methods("POST")
 	router.HandleFunc("/experiments", protected(handlers.ListExperimentsHandler(db))).Methods("GET")
 	router.HandleFunc("/experiments/{experimentID}", protected(handlers.GetExperimentHandler(db))).Methods("GET")
 	router.HandleFunc("/experiments/{experimentID}", protected(handlers.UpdateExperimentHandler(db))).Methods("PUT")
-	router.HandleFunc("/experiments/{experimentID}/add-job", protected(handlers.AddJobToExperimentHandler(db))).Methods("PUT")
+	router.HandleFunc("/experiments/{experimentID}/add-job", protected(handlers.AddJobToExperimentHandler(db, cfg))).Methods("PUT")
+	router.HandleFunc("/experiments/{experimentID}/cancel", protected(handlers.CancelExperimentHandler(db))).Methods("POST", "DELETE")
+	router.HandleFunc("/experiments/{experimentID}/events", protected(handlers.StreamExperimentEventsHandler(db))).Methods("GET")
 
+	router.HandleFunc("/jobs/callback/{rayJobID}", handlers.JobCallbackHandler(db)).Methods("POST")
 	router.HandleFunc("/jobs/{jobID}", protected(handlers.GetJobHandler(db))).Methods("GET")
-	// router.HandleFunc("/jobs/{bacalhauJobID}/logs", handlers.StreamJobLogsHandler).Methods("GET")
-	router.HandleFunc("/queue-summary", handlers.GetJobsQueueSummaryHandler(db)).Methods("GET")
-	router.HandleFunc("/worker-summary", handlers.GetWorkerSummaryHandler).Methods("GET")
+	router.HandleFunc("/jobs/{jobID}/cancel", protected(handlers.CancelJobHandler(db))).Methods("POST", "DELETE")
+	router.HandleFunc("/jobs/{jobID}/events", protected(handlers.StreamJobEventsHandler(db))).Methods("GET")
+	router.HandleFunc("/jobs/{jobID}/logs", protected(handlers.GetJobLogsHandler(db))).Methods("GET")
+	router.HandleFunc("/queue-summary", handlers.GetJobsQueueSummaryHandler(db, cfg)).Methods("GET")
+	router.HandleFunc("/worker-summary", handlers.GetWorkerSummaryHandler(db)).Methods("GET")
 
 	router.HandleFunc("/tags", protected(handlers.AddTagHandler(db))).Methods("POST")
 	router.HandleFunc("/tags", protected(handlers.ListTagsHandler(db))).Methods("GET")
@@ -73,10 +191,10 @@ func NewServer(db *gorm.DB, s3c *s3.S3Client) *mux.Router {
 	router.HandleFunc("/api-keys", protected(handlers.AddAPIKeyHandler(db))).Methods("POST")
 	router.HandleFunc("/api-keys", protected(handlers.ListAPIKeysHandler(db))).Methods("GET")
 
-	router.HandleFunc("/stripe", handlers.StripeFulfillmentHandler(db)).Methods("POST")
-	router.HandleFunc("/stripe/checkout", protected(handlers.StripeCreateCheckoutSessionHandler(db))).Methods("POST")
+	router.HandleFunc("/stripe", handlers.StripeFulfillmentHandler(db, cfg)).Methods("POST")
+	router.HandleFunc("/stripe/checkout", protected(handlers.StripeCreateCheckoutSessionHandler(db, cfg))).Methods("POST")
 	router.HandleFunc("/stripe/subscription", protected(handlers.StripeGetSubscriptionHandler(db))).Methods("GET")
-	router.HandleFunc("/stripe/plan-details", protected(handlers.StripeGetPlanDetailsHandler())).Methods("GET")
+	router.HandleFunc("/stripe/plan-details", protected(handlers.StripeGetPlanDetailsHandler(cfg))).Methods("GET")
 	router.HandleFunc("/stripe/subscription/check", protected(handlers.StripeCheckSubscriptionHandler(db))).Methods("GET")
 	router.HandleFunc("/stripe/billing-portal", protected(handlers.StripeCreateBillingPortalSessionHandler(db))).Methods("POST")
 	router.HandleFunc("/transactions", protected(handlers.ListTransactionsHandler(db))).Methods("GET")
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labdao/plex/gateway/config"
+)
+
+func TestCancelRoutesAcceptPostAndDelete(t *testing.T) {
+	router := NewServer(config.Default(), nil, nil)
+	for _, path := range []string{"/jobs/1/cancel", "/experiments/1/cancel"} {
+		for method, status := range map[string]int{
+			// the request reaches the authentication of the handler
+			http.MethodPost:   http.StatusUnauthorized,
+			http.MethodDelete: http.StatusUnauthorized,
+			http.MethodPatch:  http.StatusMethodNotAllowed,
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+			if w.Code != status {
+				t.Errorf("%s %s: status = %d, want %d", method, path, w.Code, status)
+			}
+		}
+	}
+}
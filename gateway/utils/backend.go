@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/internal/ipwl"
+	"github.com/labdao/plex/internal/localexec"
+	"github.com/labdao/plex/internal/ray"
+	s3client "github.com/labdao/plex/internal/s3"
+	"gorm.io/gorm"
+)
+
+// ErrBackendJobNotFound is returned by a JobBackend when it has no record of
+// the job it is asked about.
+var ErrBackendJobNotFound = errors.New("job not found in backend")
+
+// SubmitResult is what a backend answers when a job is handed to it. For
+// service jobs Body holds the full response, for batch jobs only the
+// submission acknowledgement.
+type SubmitResult struct {
+	StatusCode int
+	Body       []byte
+}
+
+// JobBackend is where the queue sends jobs to actually run. The backend is
+// picked per models.Model through its QueueType.
+type JobBackend interface {
+	Submit(ctx context.Context, job *models.Job, inputs map[string]interface{}, db *gorm.DB) (*SubmitResult, error)
+	Status(job *models.Job) (models.JobState, error)
+	Cancel(job *models.Job) error
+	Logs(job *models.Job) (string, error)
+	// Outputs lists the keys of the response documents produced so far.
+	Outputs(job *models.Job) ([]string, error)
+	ReadOutput(ctx context.Context, job *models.Job, key string, db *gorm.DB) ([]byte, error)
+}
+
+// LogFollower is implemented by backends that can stream the logs of a job
+// as they are written, instead of having them fetched over and over.
+type LogFollower interface {
+	// FollowLogs hands the logs of the job, from the start, to write until
+	// the job finished or ctx is cancelled.
+	FollowLogs(ctx context.Context, job *models.Job, write func(chunk string) error) error
+}
+
+// StatusLister is implemented by backends that can report the state of all
+// their jobs in a single call, which saves a request per job when monitoring.
+type StatusLister interface {
+	ListStatuses() (map[string]models.JobState, error)
+}
+
+var rayBackend = &RayBackend{}
+
+// GetJobBackend returns the backend for a model. JOB_BACKEND overrides the
+// model's own choice, which is handy to run everything locally.
+func GetJobBackend(model models.Model) JobBackend {
+	queueType := model.QueueType
+	if override := settings.Ray.Backend; override != "" {
+		queueType = models.QueueType(override)
+	}
+	switch queueType {
+	case models.QueueTypeExec:
+		return getExecBackend()
+	default:
+		return rayBackend
+	}
+}
+
+type RayBackend struct{}
+
+func (b *RayBackend) Submit(ctx context.Context, job *models.Job, inputs map[string]interface{}, db *gorm.DB) (*SubmitResult, error) {
+	resp, err := ray.SubmitRayJob(ctx, *job, job.Model.S3URI, job.RayJobID, inputs, db)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	return &SubmitResult{StatusCode: resp.StatusCode, Body: body}, nil
+}
+
+func (b *RayBackend) Status(job *models.Job) (models.JobState, error) {
+	state, err := ray.GetRayJobState(job.RayJobID)
+	if errors.Is(err, ray.ErrJobNotFound) {
+		return "", ErrBackendJobNotFound
+	}
+	return state, err
+}
+
+func (b *RayBackend) ListStatuses() (map[string]models.JobState, error) {
+	return ray.ListRayJobStates()
+}
+
+func (b *RayBackend) Cancel(job *models.Job) error {
+	err := ray.StopRayJob(job.RayJobID)
+	if errors.Is(err, ray.ErrJobNotFound) {
+		return ErrBackendJobNotFound
+	}
+	return err
+}
+
+func (b *RayBackend) FollowLogs(ctx context.Context, job *models.Job, write func(chunk string) error) error {
+	err := ray.TailRayJobLogs(ctx, job.RayJobID, write)
+	if errors.Is(err, ray.ErrJobNotFound) {
+		return ErrBackendJobNotFound
+	}
+	return err
+}
+
+func (b *RayBackend) Logs(job *models.Job) (string, error) {
+	logs, err := ray.GetRayJobLogs(job.RayJobID)
+	if errors.Is(err, ray.ErrJobNotFound) {
+		return "", ErrBackendJobNotFound
+	}
+	return logs, err
+}
+
+func (b *RayBackend) Outputs(job *models.Job) ([]string, error) {
+	bucketName := settings.Bucket.Name
+	prefix := fmt.Sprintf("%s-", job.RayJobID)
+
+	s3client, err := s3client.NewS3Client()
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 client: %v", err)
+	}
+	return s3client.ListFilesInDirectory(bucketName, prefix)
+}
+
+func (b *RayBackend) ReadOutput(ctx context.Context, job *models.Job, key string, db *gorm.DB) ([]byte, error) {
+	return GetRayJobResponseFromS3(ctx, key, job, db), nil
+}
+
+// ExecBackend runs the model's rayJobEntrypoint as a local process instead of
+// submitting it to a Ray cluster, so the gateway pipeline works without Ray.
+type ExecBackend struct {
+	runner *localexec.Runner
+}
+
+var execBackend *ExecBackend
+var execBackendOnce sync.Once
+
+func getExecBackend() *ExecBackend {
+	execBackendOnce.Do(func() {
+		workDir := settings.Ray.ExecWorkDir
+		if workDir == "" {
+			workDir = "."
+		}
+		outputDir := settings.Ray.ExecOutputDir
+		if outputDir == "" {
+			outputDir = filepath.Join(os.TempDir(), "plex-exec")
+		}
+		execBackend = &ExecBackend{runner: localexec.NewRunner(workDir, outputDir)}
+	})
+	return execBackend
+}
+
+func (b *ExecBackend) Submit(ctx context.Context, job *models.Job, inputs map[string]interface{}, db *gorm.DB) (*SubmitResult, error) {
+	var model ipwl.Model
+	if err := json.Unmarshal(job.Model.ModelJson, &model); err != nil {
+		return nil, err
+	}
+	adjustedInputs, err := ray.AdjustInputs(inputs)
+	if err != nil {
+		return nil, err
+	}
+	adjustedInputs["uuid"] = job.RayJobID
+	inputsJSON, err := json.Marshal(adjustedInputs)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{
+		"REQUEST_UUID":   job.RayJobID,
+		"RAY_JOB_INPUTS": string(inputsJSON),
+	}
+	for name, value := range ray.JobCallbackEnv(job.RayJobID) {
+		env[name] = value
+	}
+	if traceparent := Traceparent(ctx); traceparent != "" {
+		env["TRACEPARENT"] = traceparent
+	}
+
+	if job.JobType == models.JobTypeService {
+		var cancel context.CancelFunc
+		if job.Model.MaxRunningTime > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(job.Model.MaxRunningTime)*time.Second)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+		output, err := b.runner.Run(ctx, job.RayJobID, model.RayJobEntrypoint, env)
+		if ctx.Err() == context.DeadlineExceeded {
+			return &SubmitResult{StatusCode: http.StatusGatewayTimeout, Body: output}, nil
+		} else if err != nil {
+			jobLogger(job).Error("Local process failed", "error", err)
+			return &SubmitResult{StatusCode: http.StatusInternalServerError, Body: output}, nil
+		}
+		return &SubmitResult{StatusCode: http.StatusOK, Body: output}, nil
+	}
+
+	if err := b.runner.Start(job.RayJobID, model.RayJobEntrypoint, env); err != nil {
+		return nil, err
+	}
+	return &SubmitResult{StatusCode: http.StatusOK}, nil
+}
+
+func (b *ExecBackend) Status(job *models.Job) (models.JobState, error) {
+	state, err := b.runner.State(job.RayJobID)
+	if errors.Is(err, localexec.ErrNotFound) {
+		return "", ErrBackendJobNotFound
+	}
+	return models.JobState(state), err
+}
+
+func (b *ExecBackend) Cancel(job *models.Job) error {
+	err := b.runner.Stop(job.RayJobID)
+	if errors.Is(err, localexec.ErrNotFound) {
+		return ErrBackendJobNotFound
+	}
+	return err
+}
+
+func (b *ExecBackend) Logs(job *models.Job) (string, error) {
+	logs, err := b.runner.Logs(job.RayJobID)
+	if errors.Is(err, localexec.ErrNotFound) {
+		return "", ErrBackendJobNotFound
+	}
+	return logs, err
+}
+
+func (b *ExecBackend) Outputs(job *models.Job) ([]string, error) {
+	return b.runner.Outputs(job.RayJobID)
+}
+
+func (b *ExecBackend) ReadOutput(ctx context.Context, job *models.Job, key string, db *gorm.DB) ([]byte, error) {
+	return os.ReadFile(key)
+}
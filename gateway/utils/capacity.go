@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/labdao/plex/gateway/config"
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/internal/ray"
+	"gorm.io/gorm"
+)
+
+// ClusterCapacity is the total amount of resources that jobs may use at the
+// same time. A zero value leaves that resource unlimited.
+type ClusterCapacity struct {
+	CPU      float64 `json:"cpu"`
+	MemoryGb int     `json:"memoryGb"`
+	GPU      int     `json:"gpu"`
+}
+
+// ClusterCapacityFromConfig returns the capacity configured through
+// CLUSTER_CPU_CAPACITY, CLUSTER_MEMORY_GB_CAPACITY and CLUSTER_GPU_CAPACITY.
+func ClusterCapacityFromConfig(queue config.Queue) ClusterCapacity {
+	return ClusterCapacity{
+		CPU:      queue.ClusterCPUCapacity,
+		MemoryGb: queue.ClusterMemoryGbCap,
+		GPU:      queue.ClusterGPUCapacity,
+	}
+}
+
+func (c ClusterCapacity) IsLimited() bool {
+	return c.CPU > 0 || c.MemoryGb > 0 || c.GPU > 0
+}
+
+// resourceUsage is what the jobs in flight need in total.
+type resourceUsage struct {
+	CPU      float64 `gorm:"column:cpu"`
+	MemoryGb int     `gorm:"column:memory_gb"`
+	GPU      int     `gorm:"column:gpu"`
+}
+
+// fetchResourceUsage sums up the resources of the jobs matched by scope.
+func fetchResourceUsage(db *gorm.DB, scope func(*gorm.DB) *gorm.DB) (resourceUsage, error) {
+	var usage resourceUsage
+	err := db.Model(&models.Job{}).
+		Select("COALESCE(SUM(models.cpu), 0) AS cpu, COALESCE(SUM(models.memory_gb), 0) AS memory_gb, COALESCE(SUM(models.gpu), 0) AS gpu").
+		Joins("JOIN models ON models.id = jobs.model_id").
+		Scopes(scope).
+		Scan(&usage).Error
+	return usage, err
+}
+
+func activeJobsScope(db *gorm.DB) *gorm.DB {
+	return db.Where("jobs.job_status IN ?", activeJobStates)
+}
+
+// remaining returns how much of each resource is still free given the usage.
+// Unlimited resources are reported as practically infinite.
+func (c ClusterCapacity) remaining(usage resourceUsage) (float64, int, int) {
+	cpu, memoryGb, gpu := math.MaxFloat64, math.MaxInt32, math.MaxInt32
+	if c.CPU > 0 {
+		cpu = c.CPU - usage.CPU
+	}
+	if c.MemoryGb > 0 {
+		memoryGb = c.MemoryGb - usage.MemoryGb
+	}
+	if c.GPU > 0 {
+		gpu = c.GPU - usage.GPU
+	}
+	return cpu, memoryGb, gpu
+}
+
+// rayResourceSettleTime is how long after submission a Ray job may take to
+// show up in the cluster's resource usage.
+func rayResourceSettleTime() time.Duration {
+	return seconds(settings.Ray.ResourceSettleSeconds)
+}
+
+const rayResourcesCacheTTL = 5 * time.Second
+
+var rayResourcesCache struct {
+	mu        sync.Mutex
+	resources *ray.ClusterResources
+	fetchedAt time.Time
+}
+
+// fetchRayResources returns the Ray cluster's resources, cached for a few
+// seconds so that idle workers don't all query the dashboard.
+func fetchRayResources() (*ray.ClusterResources, error) {
+	rayResourcesCache.mu.Lock()
+	defer rayResourcesCache.mu.Unlock()
+	if rayResourcesCache.resources != nil && time.Since(rayResourcesCache.fetchedAt) < rayResourcesCacheTTL {
+		return rayResourcesCache.resources, nil
+	}
+	resources, err := ray.GetClusterResources()
+	if err != nil {
+		return nil, err
+	}
+	rayResourcesCache.resources = &resources
+	rayResourcesCache.fetchedAt = time.Now()
+	return &resources, nil
+}
+
+// unplacedRayJobs are Ray jobs the gateway handed out that Ray may not
+// account for yet.
+func unplacedRayJobs(db *gorm.DB) *gorm.DB {
+	settledBefore := time.Now().UTC().Add(-rayResourceSettleTime())
+	return db.Where("models.queue_type = ?", models.QueueTypeRay).
+		Where("jobs.job_status IN ? OR (jobs.job_status = ? AND jobs.started_at > ?)",
+			[]models.JobState{models.JobStateProcessing, models.JobStatePending}, models.JobStateRunning, settledBefore)
+}
+
+// rayRemaining returns what is free on the Ray cluster after the jobs that
+// were handed out but are not reflected in Ray's usage yet.
+func rayRemaining(resources *ray.ClusterResources, unplaced resourceUsage) (float64, float64, float64) {
+	cpu := resources.TotalCPU - resources.UsedCPU - unplaced.CPU
+	memoryGb := resources.TotalMemoryGb - resources.UsedMemoryGb - float64(unplaced.MemoryGb)
+	gpu := resources.TotalGPU - resources.UsedGPU - float64(unplaced.GPU)
+	return cpu, memoryGb, gpu
+}
+
+// fitsResources matches models whose requirements fit into what is left. A
+// model that needs none of a resource fits even if that resource is
+// overbooked.
+const fitsResources = "(models.cpu = 0 OR models.cpu <= ?) AND (models.memory_gb = 0 OR models.memory_gb <= ?) AND (models.gpu = 0 OR models.gpu <= ?)"
+
+// applyCapacityLimits restricts a query for queued jobs, joined with their
+// models, to the jobs that fit into the configured capacity and, for Ray
+// jobs, into what is free on the Ray cluster. Must run in a transaction
+// holding the capacity lock.
+func applyCapacityLimits(query *gorm.DB, tx *gorm.DB, capacity ClusterCapacity, rayResources *ray.ClusterResources) (*gorm.DB, error) {
+	if capacity.IsLimited() {
+		usage, err := fetchResourceUsage(tx, activeJobsScope)
+		if err != nil {
+			return nil, err
+		}
+		cpu, memoryGb, gpu := capacity.remaining(usage)
+		query = query.Where(fitsResources, cpu, memoryGb, gpu)
+	}
+
+	if rayResources != nil {
+		unplaced, err := fetchResourceUsage(tx, unplacedRayJobs)
+		if err != nil {
+			return nil, err
+		}
+		cpu, memoryGb, gpu := rayRemaining(rayResources, unplaced)
+		query = query.Where("models.queue_type <> ? OR ("+fitsResources+")", models.QueueTypeRay, cpu, memoryGb, gpu)
+	}
+	return query, nil
+}
+
+// rayResourcesForAdmission returns the Ray cluster resources if admission
+// control is enabled. When Ray can't be reached jobs are dispatched anyway,
+// their submission is retried like any other failed submission.
+func rayResourcesForAdmission(policy SchedulingPolicy) *ray.ClusterResources {
+	if !policy.RayAdmissionControl {
+		return nil
+	}
+	resources, err := fetchRayResources()
+	if err != nil {
+		slog.Error("Error fetching Ray cluster resources, skipping admission control", "error", err)
+		return nil
+	}
+	return resources
+}
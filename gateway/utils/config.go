@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/labdao/plex/gateway/config"
+	"github.com/stripe/stripe-go/v78"
+)
+
+// settings are what the queue, the job backends and billing are configured
+// with. The defaults apply until Configure is called at startup.
+var settings = config.Default()
+
+// Configure sets the gateway config. It must be called before the web app or
+// the queue workers start.
+func Configure(cfg *config.Config) {
+	settings = cfg
+	if cfg.Queue.InstanceID != "" {
+		InstanceID = cfg.Queue.InstanceID
+	}
+	stripe.Key = cfg.Stripe.SecretKey
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
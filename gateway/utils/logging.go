@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/labdao/plex/gateway/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jobLogger returns a logger that tags every line with the job it is about.
+// The Ray job ID changes when a job is resubmitted, so loggers are not kept
+// around across submissions.
+func jobLogger(job *models.Job) *slog.Logger {
+	logger := slog.Default().With("job_id", job.ID, "ray_job_id", job.RayJobID)
+	if job.Model.Name != "" {
+		logger = logger.With("model", job.Model.Name)
+	}
+	if sc := trace.SpanContextFromContext(jobContext(context.Background(), job)); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	return logger
+}
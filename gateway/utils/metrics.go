@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var (
+	queueJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "plex_queue_jobs",
+		Help: "Number of jobs per job state.",
+	}, []string{"state"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "plex_job_duration_seconds",
+		Help: "Time from the start of a job until it reached a final state.",
+		// jobs run from seconds to hours
+		Buckets: []float64{1, 5, 10, 30, 60, 300, 900, 1800, 3600, 7200, 14400, 43200},
+	}, []string{"model", "state"})
+	raySubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "plex_ray_submissions_total",
+		Help: "Job submissions to the backend by response status code.",
+	}, []string{"model", "status_code"})
+	jobRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "plex_job_retries_total",
+		Help: "Job submissions scheduled for a retry by response status code.",
+	}, []string{"model", "status_code"})
+	stripeUsageRecordFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "plex_stripe_usage_record_failures_total",
+		Help: "Usage records that could not be sent to Stripe.",
+	})
+)
+
+var metricsJobStates = []models.JobState{
+	models.JobStateQueued,
+	models.JobStateProcessing,
+	models.JobStatePending,
+	models.JobStateRunning,
+	models.JobStateStopped,
+	models.JobStateSucceeded,
+	models.JobStateFailed,
+}
+
+// UpdateQueueMetrics refreshes the job counts per state. It runs on every
+// scrape, so the numbers are current even if no worker is running in this
+// process.
+func UpdateQueueMetrics(db *gorm.DB) error {
+	var counts []struct {
+		JobStatus models.JobState
+		Count     int64
+	}
+	err := db.Model(&models.Job{}).
+		Select("job_status, COUNT(*) AS count").
+		Group("job_status").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	byState := make(map[models.JobState]int64, len(counts))
+	for _, count := range counts {
+		byState[count.JobStatus] = count.Count
+	}
+	queueJobs.Reset()
+	for _, state := range metricsJobStates {
+		queueJobs.WithLabelValues(string(state)).Set(float64(byState[state]))
+	}
+	return nil
+}
+
+func metricsModelName(model models.Model) string {
+	if model.Name == "" {
+		return "unknown"
+	}
+	return model.Name
+}
+
+// observeJobDuration records how long a job took once it reached a final state.
+func observeJobDuration(job *models.Job, state models.JobState) {
+	if job.StartedAt.IsZero() {
+		return
+	}
+	jobDuration.WithLabelValues(metricsModelName(job.Model), string(state)).Observe(time.Since(job.StartedAt).Seconds())
+}
+
+func observeSubmission(job *models.Job, statusCode int, err error) {
+	code := strconv.Itoa(statusCode)
+	if err != nil {
+		code = "error"
+	}
+	raySubmissions.WithLabelValues(metricsModelName(job.Model), code).Inc()
+}
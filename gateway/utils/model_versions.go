@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/internal/ipwl"
+	"gorm.io/gorm"
+)
+
+// ErrModelVersionNotFound is returned when a model has no version matching
+// the one requested.
+var ErrModelVersionNotFound = errors.New("model version not found")
+
+// SortModelVersions orders models by name and their versions from the newest
+// to the oldest.
+func SortModelVersions(versions []models.Model) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Name != versions[j].Name {
+			return versions[i].Name < versions[j].Name
+		}
+		return compareModelVersions(versions[i].Version, versions[j].Version) > 0
+	})
+}
+
+func compareModelVersions(a, b string) int {
+	aVersion, aErr := ipwl.ParseVersion(a)
+	bVersion, bErr := ipwl.ParseVersion(b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+	return aVersion.Compare(bVersion)
+}
+
+// LatestModelVersions keeps the newest version of every model that is not
+// deprecated. Models with only deprecated versions are left out.
+func LatestModelVersions(versions []models.Model) []models.Model {
+	SortModelVersions(versions)
+	var latest []models.Model
+	for _, version := range versions {
+		if version.Deprecated {
+			continue
+		}
+		if len(latest) > 0 && latest[len(latest)-1].Name == version.Name {
+			continue
+		}
+		latest = append(latest, version)
+	}
+	return latest
+}
+
+// ResolveModelVersion finds a version of the model called name. The version
+// "latest", or an empty one, resolves to the newest version that is not
+// deprecated.
+func ResolveModelVersion(db *gorm.DB, name string, version string) (models.Model, error) {
+	if version != "" && version != ipwl.LatestModelVersion {
+		var model models.Model
+		err := db.Where("name = ? AND version = ?", name, version).First(&model).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model, fmt.Errorf("%w: %s@%s", ErrModelVersionNotFound, name, version)
+		}
+		return model, err
+	}
+
+	var versions []models.Model
+	if err := db.Where("name = ?", name).Find(&versions).Error; err != nil {
+		return models.Model{}, err
+	}
+	latest := LatestModelVersions(versions)
+	if len(latest) == 0 {
+		return models.Model{}, fmt.Errorf("%w: %s has no version that is not deprecated", ErrModelVersionNotFound, name)
+	}
+	return latest[0], nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/labdao/plex/gateway/models"
+)
+
+// IsPaidUser reports whether a user is on the paid tier or has an active
+// subscription.
+func IsPaidUser(user *models.User) bool {
+	return user.Tier == models.TierPaid || user.SubscriptionStatus == "active"
+}
+
+// paidUserSQL is IsPaidUser for queries that join the users table. It takes
+// the arguments returned by paidUserArgs.
+const paidUserSQL = "(users.tier = ? OR users.subscription_status = ?)"
+
+func paidUserArgs() []interface{} {
+	return []interface{}{models.TierPaid, "active"}
+}
+
+// ErrInvalidJobPriority is returned for priorities that no user may request.
+var ErrInvalidJobPriority = errors.New("invalid job priority")
+
+// ErrPaidJobPriority is returned when a free user requests a priority that is
+// reserved for paid users.
+var ErrPaidJobPriority = errors.New("job priority is reserved for paid users")
+
+// MaxJobPriority is the highest priority a user may give their jobs.
+func MaxJobPriority(user *models.User) int {
+	if IsPaidUser(user) {
+		return settings.Queue.MaxJobPriorityPaid
+	}
+	return settings.Queue.MaxJobPriorityFree
+}
+
+// ResolveJobPriority returns the priority for new jobs of a user. Paid users
+// land in a higher lane by default, an explicit request must stay within the
+// bounds of the user's tier.
+func ResolveJobPriority(user *models.User, requested *int) (int, error) {
+	maxPriority := MaxJobPriority(user)
+	if requested == nil {
+		if !IsPaidUser(user) {
+			return 0, nil
+		}
+		if settings.Queue.DefaultJobPriorityPaid > maxPriority {
+			return maxPriority, nil
+		}
+		return settings.Queue.DefaultJobPriorityPaid, nil
+	}
+	if *requested >= 0 && *requested <= maxPriority {
+		return *requested, nil
+	}
+	if *requested > maxPriority && *requested <= settings.Queue.MaxJobPriorityPaid && !IsPaidUser(user) {
+		return 0, fmt.Errorf("%w: priorities above %d need a paid plan", ErrPaidJobPriority, maxPriority)
+	}
+	return 0, fmt.Errorf("%w: priority must be between 0 and %d", ErrInvalidJobPriority, maxPriority)
+}
@@ -1,10 +1,11 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net/http"
@@ -19,8 +20,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/internal/ipwl"
-	"github.com/labdao/plex/internal/ray"
 	s3client "github.com/labdao/plex/internal/s3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -29,181 +31,290 @@ import (
 type RayQueue struct {
 	db         *gorm.DB
 	maxWorkers int
+	policy     SchedulingPolicy
+	workers    sync.WaitGroup
+	// stopped is closed once all workers returned
+	stopped chan struct{}
 }
 
-type WorkerState struct {
-	ID         int
-	Busy       bool
-	CurrentJob *string
+// SchedulingPolicy controls how queued jobs are shared between users. A cap
+// of 0 means a user may have any number of jobs in flight.
+type SchedulingPolicy struct {
+	MaxJobsPerUser     int
+	MaxJobsPerPaidUser int
+	// PaidTierWeight is how many jobs a paid user gets in flight for every
+	// job of a free user before being considered to have had their share.
+	PaidTierWeight int
+	// PriorityAgingSeconds is how long a job has to wait to gain one level of
+	// priority, so low priority jobs are never starved. 0 disables aging.
+	PriorityAgingSeconds int
+	// Capacity limits the resources of all jobs in flight together.
+	Capacity ClusterCapacity
+	// RayAdmissionControl holds back Ray jobs that don't fit into the
+	// resources currently free on the Ray cluster.
+	RayAdmissionControl bool
 }
 
-var workerStates []*WorkerState
-
-func NewRayQueue(db *gorm.DB, maxWorkers int) *RayQueue {
+func NewRayQueue(db *gorm.DB, maxWorkers int, policy SchedulingPolicy) *RayQueue {
 	return &RayQueue{
 		db:         db,
 		maxWorkers: maxWorkers,
+		policy:     policy,
+		stopped:    make(chan struct{}),
 	}
 }
 
-var maxRetryCountFor500 = GetEnvAsInt("MAX_RETRY_COUNT_FOR_500", 2)
-var maxRetryCountFor404 = GetEnvAsInt("MAX_RETRY_COUNT_FOR_404", 1)
-
 // Helper function to get a normally distributed random delay
-// TODO_PR979: Make these env variables
 func getRandomDelay(retryCount int, base time.Duration, factor float64, stdDev time.Duration) time.Duration {
 	mean := float64(base.Nanoseconds()) * math.Pow(factor, float64(retryCount))
 	delay := mean + rand.NormFloat64()*float64(stdDev.Nanoseconds())
 	return time.Duration(delay)
 }
 
-var once sync.Once
+// WorkerHeartbeatInterval is how often queue workers report in. Workers that
+// missed a few heartbeats are considered gone.
+func WorkerHeartbeatInterval() time.Duration {
+	return seconds(settings.Queue.HeartbeatSeconds)
+}
 
-func StartJobQueues(db *gorm.DB, maxWorkers int) error {
-	once.Do(func() {
-		rq := NewRayQueue(db, maxWorkers)
-		rq.StartWorkers()
-	})
-	return nil
+// staleWorkerRetention is how long rows of workers that stopped sending
+// heartbeats are kept around before being cleaned up.
+const staleWorkerRetention = 24 * time.Hour
+
+// StartJobQueues starts the queue workers of this process. Any number of
+// processes, `plex web` or `plex worker`, can run workers against the same
+// database. The workers stop claiming jobs once ctx is cancelled, see
+// RayQueue.Shutdown.
+func StartJobQueues(ctx context.Context, db *gorm.DB, maxWorkers int, policy SchedulingPolicy) (*RayQueue, error) {
+	rq := NewRayQueue(db, maxWorkers, policy)
+	if err := rq.StartWorkers(ctx); err != nil {
+		return nil, err
+	}
+	return rq, nil
 }
 
-func (rq *RayQueue) StartWorkers() {
-	workerStates = make([]*WorkerState, rq.maxWorkers) // initialize the slice based on maxWorkers
+func (rq *RayQueue) StartWorkers(ctx context.Context) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	now := time.Now().UTC()
 	for i := 0; i < rq.maxWorkers; i++ {
-		workerStates[i] = &WorkerState{ID: i} // initialize each worker state
-		go func(workerID int) {
-			fmt.Printf("Starting worker %d\n", workerID)
-			rq.worker(workerID)
-		}(i)
+		worker := models.QueueWorker{
+			ID:              fmt.Sprintf("%s-%d", InstanceID, i),
+			InstanceID:      InstanceID,
+			Hostname:        hostname,
+			StartedAt:       now,
+			LastHeartbeatAt: now,
+		}
+		if err := rq.db.Save(&worker).Error; err != nil {
+			return fmt.Errorf("error registering worker %s: %v", worker.ID, err)
+		}
+		rq.workers.Add(1)
+		go func(workerID string) {
+			defer rq.workers.Done()
+			slog.Info("Starting worker", "worker_id", workerID)
+			rq.worker(ctx, workerID)
+			slog.Info("Worker stopped", "worker_id", workerID)
+		}(worker.ID)
 	}
+	go func() {
+		rq.workers.Wait()
+		close(rq.stopped)
+	}()
+	go rq.heartbeat()
+	return nil
 }
 
-func (rq *RayQueue) worker(workerID int) {
-	state := workerStates[workerID]
+// Shutdown waits for the workers to finish the jobs they are processing after
+// the context passed to StartWorkers was cancelled, and unregisters them. If
+// ctx expires first, the jobs are left claimed, see ReleaseClaimedJobs.
+func (rq *RayQueue) Shutdown(ctx context.Context) error {
+	var err error
+	select {
+	case <-rq.stopped:
+	case <-ctx.Done():
+		err = fmt.Errorf("workers did not finish their jobs in time: %w", ctx.Err())
+	}
+	if dbErr := rq.db.Where("instance_id = ?", InstanceID).Delete(&models.QueueWorker{}).Error; dbErr != nil && err == nil {
+		err = fmt.Errorf("error unregistering workers: %v", dbErr)
+	}
+	return err
+}
+
+// Heartbeats go on while the workers finish their last jobs.
+func (rq *RayQueue) heartbeat() {
 	for {
-		// TEMP FIX: Marking dangling jobs to stopped after an hr. This wont be needed once we move to ray jobs
-		err := fetchAndMarkOldestRunningJobAsStopped(rq.db)
+		select {
+		case <-rq.stopped:
+			return
+		case <-time.After(WorkerHeartbeatInterval()):
+		}
+		now := time.Now().UTC()
+		err := rq.db.Model(&models.QueueWorker{}).
+			Where("instance_id = ?", InstanceID).
+			Update("last_heartbeat_at", now).Error
+		if err != nil {
+			slog.Error("Error sending worker heartbeat", "instance_id", InstanceID, "error", err)
+		}
+		err = rq.db.Where("last_heartbeat_at < ?", now.Add(-staleWorkerRetention)).Delete(&models.QueueWorker{}).Error
 		if err != nil {
-			fmt.Printf("Error marking dangling jobs to stopped: %v\n", err)
+			slog.Error("Error cleaning up stale workers", "error", err)
 		}
+	}
+}
+
+func (rq *RayQueue) setWorkerState(workerID string, job *models.Job) {
+	updates := map[string]interface{}{
+		"busy":              false,
+		"current_job_id":    nil,
+		"current_job":       nil,
+		"last_heartbeat_at": time.Now().UTC(),
+	}
+	if job != nil {
+		updates["busy"] = true
+		updates["current_job_id"] = job.ID
+		updates["current_job"] = job.RayJobID
+	}
+	if err := rq.db.Model(&models.QueueWorker{}).Where("id = ?", workerID).Updates(updates).Error; err != nil {
+		slog.Error("Error updating worker state", "worker_id", workerID, "error", err)
+	}
+}
+
+func (rq *RayQueue) worker(ctx context.Context, workerID string) {
+	for ctx.Err() == nil {
 		var job models.Job
-		err = fetchAndMarkOldestQueuedJobAsProcessing(&job, models.QueueTypeRay, rq.db)
+		err := fetchAndMarkNextQueuedJobAsProcessing(&job, models.QueueTypeRay, rq.policy, rq.db)
 		if err != nil {
-			state.Busy = false
-			state.CurrentJob = nil
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				time.Sleep(10 * time.Second)
+				sleepContext(ctx, 10*time.Second)
 				continue
 			}
-			fmt.Printf("Error fetching job: %v\n", err)
-			time.Sleep(10 * time.Second)
+			slog.Error("Error fetching job", "worker_id", workerID, "error", err)
+			sleepContext(ctx, 10*time.Second)
 			continue
 		}
 
-		state.Busy = true
-		state.CurrentJob = &job.RayJobID
+		rq.setWorkerState(workerID, &job)
 
-		// Process the job
+		// Process the job. A claimed job is seen through even when shutting
+		// down, ctx only stops the worker from claiming the next one.
 		if err = processRayJob(job.ID, rq.db); err != nil {
-			fmt.Printf("Error processing job: %v\n", err)
+			jobLogger(&job).Error("Error processing job", "worker_id", workerID, "error", err)
 		}
 
-		state.Busy = false
-		state.CurrentJob = nil
-		time.Sleep(5 * time.Second) // Even after processing a job, sleep for a bit
+		rq.setWorkerState(workerID, nil)
+		sleepContext(ctx, 5*time.Second) // Even after processing a job, sleep for a bit
 	}
 }
 
-func GetWorkerSummary(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(workerStates)
+// sleepContext sleeps for d or until ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
 }
 
-func fetchAndMarkOldestRunningJobAsStopped(db *gorm.DB) error {
+func checkRunningJob(jobID uint, state models.JobState, backendErr error, db *gorm.DB) error {
 	var job models.Job
-	err := db.Where("job_status = ?", models.JobStateRunning).Order("started_at ASC").First(&job).Error
+	err := fetchJobWithModelAndExperimentData(&job, jobID, db)
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil
-		}
 		return err
 	}
-
-	if time.Since(job.StartedAt) > 1*time.Hour {
-		job.JobStatus = models.JobStateStopped
-		return db.Save(&job).Error
+	if job.JobStatus != models.JobStateRunning {
+		return nil
 	}
-
-	return nil
+	return applyBackendState(&job, state, backendErr, db)
 }
 
-func checkRunningJob(jobID uint, db *gorm.DB) error {
-	var job models.Job
-	err := fetchJobWithModelAndExperimentData(&job, jobID, db)
-	if err != nil {
-		return err
-	}
-	if err != nil && strings.Contains(err.Error(), "Job not found") {
-		fmt.Printf("Job %v , %v has missing Ray Job, failing Job\n", job.ID, job.RayJobID)
-		return setJobStatus(&job, models.JobStateFailed, fmt.Sprintf("Ray job %v not found", job.RayJobID), db)
+// applyBackendState moves a job along according to the state its backend
+// reported for it.
+func applyBackendState(job *models.Job, state models.JobState, err error, db *gorm.DB) error {
+	logger := jobLogger(job)
+	if errors.Is(err, ErrBackendJobNotFound) {
+		logger.Warn("Job has missing Ray job, failing job")
+		return setJobStatus(job, models.JobStateFailed, fmt.Sprintf("Ray job %v not found", job.RayJobID), db)
 	} else if err != nil {
 		return err
 	}
 
-	if ray.JobIsPending(job.RayJobID) {
-		fmt.Printf("Job %v , %v is still in pending state nothing to do\n", job.ID, job.RayJobID)
+	switch state {
+	case models.JobStatePending:
+		logger.Debug("Job is still pending, nothing to do")
 		return nil
-	} else if ray.JobIsRunning(job.RayJobID) {
-		fmt.Printf("Job %v , %v is still running nothing to do\n", job.ID, job.RayJobID)
+	case models.JobStateRunning:
+		logger.Debug("Job is still running, nothing to do")
 		return nil
-	} else if ray.JobFailed(job.RayJobID) {
-		fmt.Printf("Job %v , %v failed, updating status\n", job.ID, job.RayJobID)
-		return setJobStatus(&job, models.JobStateFailed, fmt.Sprintf("Ray job %v failed", job.RayJobID), db)
-	} else if ray.JobStopped(job.RayJobID) {
-		fmt.Printf("Job %v , %v was stopped, updating status\n", job.ID, job.RayJobID)
-		return setJobStatus(&job, models.JobStateStopped, fmt.Sprintf("Ray job %v was stopped", job.RayJobID), db)
-	} else if ray.JobSucceeded(job.RayJobID) {
-		fmt.Printf("Job %v , %v completed, updating status and adding output files\n", job.ID, job.RayJobID)
-		processNewFiles(&job, db)
-		return setJobStatus(&job, models.JobStateSucceeded, "", db)
-	} else {
-		fmt.Printf("Job %v , %v had unexpected Ray state %v, marking as failed\n", job.ID, job.RayJobID, job.JobStatus)
-		return setJobStatus(&job, models.JobStateFailed, fmt.Sprintf("unexpected Ray state %v", job.JobStatus), db)
+	case models.JobStateFailed:
+		logger.Info("Job failed, updating status")
+		return setJobStatus(job, models.JobStateFailed, withLogTail(job, fmt.Sprintf("Ray job %v failed", job.RayJobID)), db)
+	case models.JobStateStopped:
+		logger.Info("Job was stopped, updating status")
+		return setJobStatus(job, models.JobStateStopped, fmt.Sprintf("Ray job %v was stopped", job.RayJobID), db)
+	case models.JobStateSucceeded:
+		logger.Info("Job completed, updating status and adding output files")
+		processNewFiles(job, db)
+		return setJobStatus(job, models.JobStateSucceeded, "", db)
+	default:
+		logger.Warn("Job had unexpected Ray state, marking as failed", "state", state)
+		return setJobStatus(job, models.JobStateFailed, fmt.Sprintf("unexpected Ray state %v", state), db)
 	}
 }
 
-func GetRayJobResponseFromS3(key string, job *models.Job, db *gorm.DB) []byte {
+// withLogTail appends the last lines of a failed job's logs to its error
+// message, so users can see why it failed.
+func withLogTail(job *models.Job, message string) string {
+	if settings.Queue.ErrorLogLines <= 0 {
+		return message
+	}
+	logs, err := GetJobBackend(job.Model).Logs(job)
+	if err != nil {
+		jobLogger(job).Error("Error fetching logs for failed job", "error", err)
+		return message
+	}
+	tail := strings.TrimSpace(TailLines(logs, settings.Queue.ErrorLogLines))
+	if tail == "" {
+		return message
+	}
+	return fmt.Sprintf("%s, last log lines:\n%s", message, tail)
+}
+
+func GetRayJobResponseFromS3(ctx context.Context, key string, job *models.Job, db *gorm.DB) []byte {
 	// get job uuid and experiment uuid using rayjobid
 	// s3 download file experiment uuid/job uuid/response.json
 	// return response.json
+	logger := jobLogger(job)
 	experimentId := job.ExperimentID
 	var experiment models.Experiment
 	result := db.Select("experiment_uuid").Where("id = ?", experimentId).First(&experiment)
 	if result.Error != nil {
-		log.Printf("Error fetching experiment UUID: %v", result.Error)
+		logger.Error("Error fetching experiment UUID", "error", result.Error)
 	}
-	bucketName := os.Getenv("BUCKET_NAME")
+	bucketName := settings.Bucket.Name
 	//TODO-LAB-1491: change this later to exp uuid/ job uuid
-	fmt.Printf("Downloading file from S3 with key: %s\n", key)
+	logger.Info("Downloading file from S3", "key", key)
 	fileName := filepath.Base(key)
 	s3client, err := s3client.NewS3Client()
 	if err != nil {
-		log.Printf("Error creating S3 client")
+		logger.Error("Error creating S3 client", "error", err)
 	}
 
-	err = s3client.DownloadFile(bucketName, key, fileName)
+	err = s3client.DownloadFileWithContext(ctx, bucketName, key, fileName)
 	if err != nil {
-		log.Printf("Error streaming file to response: %v\n", err)
+		logger.Error("Error streaming file to response", "key", key, "error", err)
 	}
 	file, err := os.Open(fileName)
 	if err != nil {
-		log.Printf("Failed to open file: %v\n", err)
+		logger.Error("Failed to open file", "file", fileName, "error", err)
 	}
 	defer file.Close()
 	defer os.Remove(fileName)
 	bytes, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("Failed to read file: %v\n", err)
+		logger.Error("Failed to read file", "file", fileName, "error", err)
 	}
 
 	return bytes
@@ -219,42 +330,342 @@ func setJobStatus(job *models.Job, state models.JobState, errorMessage string, d
 	if err != nil {
 		return err
 	}
+	if state == models.JobStateSucceeded || state == models.JobStateFailed || state == models.JobStateStopped {
+		observeJobDuration(job, state)
+	}
 	return nil
 }
 
-func MonitorRunningJobs(db *gorm.DB) error {
-	for {
-		var jobs []models.Job
-		if err := fetchRunningJobsWithModelData(&jobs, db); err != nil {
+// ErrJobNotCancellable is returned when cancelling a job that already reached
+// a final state.
+var ErrJobNotCancellable = errors.New("job has already finished")
+
+// CancelJob stops a job on behalf of a user. Queued jobs are simply taken out
+// of the queue, jobs that were already handed to a backend are stopped there
+// once the job is marked as stopped. If that fails, the monitor tries again.
+func CancelJob(jobID uint, db *gorm.DB) (*models.Job, error) {
+	var job models.Job
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Model").First(&job, jobID).Error; err != nil {
 			return err
 		}
-		for _, job := range jobs {
-			// Check and process new files for each job
-			if err := processNewFiles(&job, db); err != nil {
-				return err
+
+		switch job.JobStatus {
+		case models.JobStateStopped, models.JobStateSucceeded, models.JobStateFailed:
+			return ErrJobNotCancellable
+		case models.JobStatePending, models.JobStateRunning:
+			job.CancelPending = job.RayJobID != ""
+		}
+
+		job.JobStatus = models.JobStateStopped
+		job.Error = "cancelled by user"
+		job.CompletedAt = time.Now().UTC()
+		if err := tx.Save(&job).Error; err != nil {
+			return err
+		}
+
+		inferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			RayJobID:     job.RayJobID,
+			RetryCount:   job.RetryCount,
+			JobStatus:    models.JobStateStopped,
+			EventTime:    time.Now().UTC(),
+			EventType:    models.EventTypeJobStopped,
+			EventMessage: "cancelled by user",
+		}
+		return tx.Create(&inferenceEvent).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	jobLogger(&job).Info("Job was cancelled")
+	observeJobDuration(&job, models.JobStateStopped)
+	// Local processes can only be stopped by the process that started them,
+	// its monitor picks the cancellation up
+	if job.CancelPending && (!runsInProcess(&job) || job.ClaimedBy == InstanceID) {
+		if err := cancelInBackend(&job, db); err != nil {
+			jobLogger(&job).Warn("Error stopping job in backend, will retry", "error", err)
+		}
+	}
+	return &job, nil
+}
+
+// cancelInBackend stops a job that was marked as stopped in its backend and
+// clears its CancelPending flag once that worked.
+func cancelInBackend(job *models.Job, db *gorm.DB) error {
+	err := GetJobBackend(job.Model).Cancel(job)
+	if err != nil && !errors.Is(err, ErrBackendJobNotFound) {
+		return err
+	}
+	job.CancelPending = false
+	return db.Model(job).Update("cancel_pending", false).Error
+}
+
+// retryPendingCancels stops the jobs in their backend whose cancellation
+// failed before.
+func retryPendingCancels(db *gorm.DB, locked bool) {
+	var jobs []models.Job
+	query := db.Preload("Model").Where("cancel_pending = ?", true)
+	if !locked {
+		query = query.Where("claimed_by = ?", InstanceID)
+	}
+	if err := query.Find(&jobs).Error; err != nil {
+		slog.Error("Error fetching jobs to cancel", "error", err)
+		return
+	}
+	for i := range jobs {
+		if runsInProcess(&jobs[i]) && jobs[i].ClaimedBy != InstanceID {
+			if !instanceAlive(jobs[i].ClaimedBy, db) {
+				// The process went away, and its local processes with it
+				db.Model(&jobs[i]).Update("cancel_pending", false)
 			}
+			continue
+		}
+		if err := cancelInBackend(&jobs[i], db); err != nil {
+			jobLogger(&jobs[i]).Warn("Error stopping job in backend, will retry", "error", err)
+		}
+	}
+}
 
-			// Continue monitoring job status
-			if err := checkRunningJob(job.ID, db); err != nil {
+func fetchJobState(jobID uint, db *gorm.DB) models.JobState {
+	var job models.Job
+	if err := db.Select("job_status").First(&job, jobID).Error; err != nil {
+		return ""
+	}
+	return job.JobStatus
+}
+
+// jobCheckRequests wakes up the monitor before its next tick, e.g. when a job
+// reported its completion through a callback.
+var jobCheckRequests = make(chan struct{}, 1)
+
+// RequestJobStatusCheck makes MonitorRunningJobs check all running jobs right
+// away instead of waiting for the next tick.
+func RequestJobStatusCheck() {
+	select {
+	case jobCheckRequests <- struct{}{}:
+	default:
+		// a check is already pending
+	}
+}
+
+// monitorLockKey is the Postgres advisory lock that makes sure only one of
+// the gateway and worker processes monitors running jobs at a time.
+const monitorLockKey = 7340001
+
+// MonitorRunningJobs checks the running jobs for new outputs and state
+// changes until ctx is cancelled.
+func MonitorRunningJobs(ctx context.Context, db *gorm.DB) error {
+	for {
+		// The lock is held by a session of its own rather than a
+		// transaction, so no transaction stays open while the jobs are
+		// checked
+		err := db.Connection(func(conn *gorm.DB) error {
+			var locked bool
+			if err := conn.Raw("SELECT pg_try_advisory_lock(?)", monitorLockKey).Scan(&locked).Error; err != nil {
 				return err
 			}
+			if locked {
+				defer func() {
+					if err := conn.Exec("SELECT pg_advisory_unlock(?)", monitorLockKey).Error; err != nil {
+						slog.Error("Error releasing the monitor lock", "error", err)
+					}
+				}()
+			}
+			// Without the lock, another process monitors the running jobs
+			// and this one only checks on the local processes it started
+			retryPendingCancels(db, locked)
+			return monitorRunningJobs(db, locked)
+		})
+		if err != nil {
+			slog.Error("Error monitoring running jobs", "error", err)
+		}
+		slog.Debug("Finished monitoring all running jobs, will recheck after the interval")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(seconds(settings.Queue.MonitorIntervalSeconds)):
+		case <-jobCheckRequests:
+		}
+	}
+}
+
+// runsInProcess reports whether job runs as a local process of the gateway or
+// worker process that submitted it. Only that process knows about it.
+func runsInProcess(job *models.Job) bool {
+	_, ok := GetJobBackend(job.Model).(*ExecBackend)
+	return ok
+}
+
+// instanceAlive reports whether the gateway or worker process instanceID
+// still sends worker heartbeats.
+func instanceAlive(instanceID string, db *gorm.DB) bool {
+	var count int64
+	cutoff := time.Now().UTC().Add(-3 * WorkerHeartbeatInterval())
+	err := db.Model(&models.QueueWorker{}).
+		Where("instance_id = ? AND last_heartbeat_at >= ?", instanceID, cutoff).
+		Count(&count).Error
+	// Rather keep watching than fail jobs on a database hiccup
+	return err != nil || count > 0
+}
+
+// monitoredHere reports whether this process checks on job. Jobs that run as
+// local processes are checked by the process that started them, everything
+// else by the process holding the monitor lock. The lock holder fails local
+// jobs whose process went away, as nothing can check on them anymore.
+func monitoredHere(job *models.Job, locked bool, db *gorm.DB) bool {
+	if !runsInProcess(job) {
+		return locked
+	}
+	if job.ClaimedBy == InstanceID {
+		return true
+	}
+	if locked && !instanceAlive(job.ClaimedBy, db) {
+		jobLogger(job).Warn("Process running the job is gone, failing job", "instance_id", job.ClaimedBy)
+		if err := setJobStatus(job, models.JobStateFailed, "the gateway process running the job stopped", db); err != nil {
+			jobLogger(job).Error("Error updating job status", "error", err)
+		}
+	}
+	return false
+}
+
+type listedStatuses struct {
+	states map[string]models.JobState
+	err    error
+}
+
+func monitorRunningJobs(db *gorm.DB, locked bool) error {
+	var jobs []models.Job
+	query := db
+	if !locked {
+		query = db.Where("claimed_by = ?", InstanceID)
+	}
+	if err := fetchRunningJobsWithModelData(&jobs, query); err != nil {
+		return err
+	}
+
+	// Backends that can list their jobs are asked once per tick, not per job
+	listed := make(map[JobBackend]*listedStatuses)
+	for i := range jobs {
+		job := &jobs[i]
+		if !monitoredHere(job, locked, db) {
+			continue
+		}
+		// Check and process new files for each job
+		if err := processNewFiles(job, db); err != nil {
+			jobLogger(job).Error("Error processing new files", "error", err)
+			continue
+		}
+
+		backend := GetJobBackend(job.Model)
+		state, err := lookupJobState(backend, job, listed)
+		if err := checkRunningJob(job.ID, state, err, db); err != nil {
+			jobLogger(job).Error("Error updating job status", "error", err)
 		}
-		log.Println("Finished monitoring all running jobs, will recheck after the interval.")
-		time.Sleep(10 * time.Second) // wait for 10 seconds before checking again
 	}
+	return nil
+}
+
+func lookupJobState(backend JobBackend, job *models.Job, listed map[JobBackend]*listedStatuses) (models.JobState, error) {
+	lister, ok := backend.(StatusLister)
+	if !ok {
+		return backend.Status(job)
+	}
+	statuses, ok := listed[backend]
+	if !ok {
+		states, err := lister.ListStatuses()
+		statuses = &listedStatuses{states: states, err: err}
+		listed[backend] = statuses
+	}
+	if statuses.err != nil {
+		return "", statuses.err
+	}
+	state, ok := statuses.states[job.RayJobID]
+	if !ok {
+		// The job may have been submitted after the list was taken, only
+		// a direct lookup tells whether the backend really lost it
+		return backend.Status(job)
+	}
+	return state, nil
 }
 
 func fetchRunningJobsWithModelData(jobs *[]models.Job, db *gorm.DB) error {
 	return db.Preload("Model").Where("job_status = ?", models.JobStateRunning).Where("job_type", models.JobTypeJob).Find(jobs).Error
 }
 
-func fetchAndMarkOldestQueuedJobAsProcessing(job *models.Job, queueType models.QueueType, db *gorm.DB) error {
+var activeJobStates = []models.JobState{models.JobStateProcessing, models.JobStatePending, models.JobStateRunning}
+
+// capacityLockKey is the Postgres advisory lock held while claiming a job
+// when the cluster capacity is limited.
+const capacityLockKey = 7340002
+
+// fetchAndMarkNextQueuedJobAsProcessing claims the next job to run. Jobs with
+// the highest effective priority (their priority plus one level per aging
+// period waited) go first. Within a priority, users with the fewest jobs in
+// flight (weighted by tier) go first, and users at their concurrency cap are
+// skipped. Within a user, oldest first. Jobs that do not fit into the
+// remaining cluster capacity, or into what is free on the Ray cluster, wait.
+func fetchAndMarkNextQueuedJobAsProcessing(job *models.Job, queueType models.QueueType, policy SchedulingPolicy, db *gorm.DB) error {
+	freeCap, paidCap := policy.MaxJobsPerUser, policy.MaxJobsPerPaidUser
+	if freeCap <= 0 {
+		freeCap = math.MaxInt32
+	}
+	if paidCap <= 0 {
+		paidCap = math.MaxInt32
+	}
+	paidWeight := policy.PaidTierWeight
+	if paidWeight <= 0 {
+		paidWeight = 1
+	}
+	agingSeconds := policy.PriorityAgingSeconds
+	if agingSeconds <= 0 {
+		agingSeconds = math.MaxInt32
+	}
+
+	// Ask Ray before opening the transaction, it's a network round trip
+	rayResources := rayResourcesForAdmission(policy)
+
 	return db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("job_status = ?", models.JobStateQueued).Order("created_at ASC").First(job).Error; err != nil {
+		activeJobs := tx.Model(&models.Job{}).
+			Select("wallet_address, count(*) AS active_count").
+			Where("job_status IN ?", activeJobStates).
+			Group("wallet_address")
+
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "jobs"}, Options: "SKIP LOCKED"}).
+			Select("jobs.*").
+			Joins("LEFT JOIN users ON users.wallet_address = jobs.wallet_address").
+			Joins("LEFT JOIN (?) AS active ON active.wallet_address = jobs.wallet_address", activeJobs)
+
+		if policy.Capacity.IsLimited() || rayResources != nil {
+			// Claims are serialized so concurrent workers can't overbook the cluster
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", capacityLockKey).Error; err != nil {
+				return err
+			}
+			var err error
+			query, err = applyCapacityLimits(query.Joins("JOIN models ON models.id = jobs.model_id"), tx, policy.Capacity, rayResources)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := query.
+			Where("jobs.job_status = ?", models.JobStateQueued).
+			Where("jobs.next_attempt_at IS NULL OR jobs.next_attempt_at <= ?", time.Now().UTC()).
+			Where("COALESCE(active.active_count, 0) < CASE WHEN "+paidUserSQL+" THEN ? ELSE ? END",
+				append(paidUserArgs(), paidCap, freeCap)...).
+			Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL: "jobs.priority + FLOOR(EXTRACT(EPOCH FROM (NOW() - jobs.created_at)) / ?) DESC, " +
+					"COALESCE(active.active_count, 0)::float / CASE WHEN " + paidUserSQL + " THEN ? ELSE 1 END ASC, jobs.created_at ASC",
+				Vars: append(append([]interface{}{agingSeconds}, paidUserArgs()...), paidWeight),
+			}}).
+			Take(job).Error
+		if err != nil {
 			return err
 		}
 
 		job.JobStatus = models.JobStateProcessing
+		job.ClaimedBy = InstanceID
+		job.LeaseExpiresAt = time.Now().UTC().Add(jobLeaseDuration())
 		if err := tx.Save(job).Error; err != nil {
 			return err
 		}
@@ -283,17 +694,22 @@ func fetchJobWithModelAndExperimentData(job *models.Job, id uint, db *gorm.DB) e
 	return db.Preload("Model").Preload("Experiment").First(&job, id).Error
 }
 
-func fetchLatestInferenceEvent(inferenceEvent *models.InferenceEvent, jobID uint, db *gorm.DB) error {
-	return db.Where("job_id = ?", jobID).Order("event_time DESC").First(inferenceEvent).Error
-}
-
-func processRayJob(jobID uint, db *gorm.DB) error {
-	fmt.Printf("Processing Ray Job %v\n", jobID)
+func processRayJob(jobID uint, db *gorm.DB) (err error) {
 	var job models.Job
-	err := fetchJobWithModelAndExperimentData(&job, jobID, db)
+	err = fetchJobWithModelAndExperimentData(&job, jobID, db)
 	if err != nil {
 		return err
 	}
+	jobLogger(&job).Info("Processing job")
+
+	ctx := jobContext(context.Background(), &job)
+	recordQueueWait(ctx, &job)
+	ctx, span := tracer.Start(ctx, "job.process", trace.WithAttributes(
+		attribute.Int("job_id", int(job.ID)),
+		attribute.String("model", job.Model.Name),
+		attribute.Int("retry_count", job.RetryCount),
+	))
+	defer func() { endSpan(span, err) }()
 
 	var ModelJson ipwl.Model
 	if err := json.Unmarshal(job.Model.ModelJson, &ModelJson); err != nil {
@@ -302,14 +718,23 @@ func processRayJob(jobID uint, db *gorm.DB) error {
 
 	if job.RayJobID == "" && job.JobStatus == models.JobStateProcessing {
 		rayJobID := uuid.New().String()
-		log.Printf("Here is the UUID for the job: %v\n", rayJobID)
+		// Only move on if the job was not cancelled since it was claimed
+		result := db.Model(&models.Job{}).
+			Where("id = ? AND job_status = ?", job.ID, models.JobStateProcessing).
+			Updates(map[string]interface{}{"ray_job_id": rayJobID, "job_status": models.JobStatePending})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			jobLogger(&job).Info("Job was cancelled before submission, skipping")
+			return nil
+		}
 		job.RayJobID = rayJobID
 		job.JobStatus = models.JobStatePending
-		if err := db.Save(&job).Error; err != nil {
-			return err
-		}
-		createInferenceEvent(job.ID, models.JobStatePending, job.RayJobID, 0, db)
-		if err := submitRayJobAndUpdateID(&job, db); err != nil {
+		jobLogger(&job).Info("Assigned Ray job ID")
+		createInferenceEvent(job.ID, models.JobStatePending, job.RayJobID, job.RetryCount, db)
+		span.SetAttributes(attribute.String("ray_job_id", job.RayJobID))
+		if err := submitRayJobAndUpdateID(ctx, &job, db); err != nil {
 			return err
 		}
 	}
@@ -404,8 +829,8 @@ func PrettyPrintRayJobResponse(response models.RayJobResponse) (string, error) {
 	return string(prettyJSON), nil
 }
 
-func submitRayJobAndUpdateID(job *models.Job, db *gorm.DB) error {
-	log.Println("Preparing to submit job to Ray service")
+func submitRayJobAndUpdateID(ctx context.Context, job *models.Job, db *gorm.DB) error {
+	logger := jobLogger(job)
 	var jobInputs map[string]interface{}
 	if err := json.Unmarshal(job.Inputs, &jobInputs); err != nil {
 		return err
@@ -414,93 +839,48 @@ func submitRayJobAndUpdateID(job *models.Job, db *gorm.DB) error {
 	for key, value := range jobInputs {
 		inputs[key] = []interface{}{value}
 	}
-	modelPath := job.Model.S3URI
 
-	log.Printf("Submitting to Ray with inputs: %+v\n", inputs)
-	createInferenceEvent(job.ID, models.JobStateRunning, job.RayJobID, 0, db)
+	logger.Debug("Submitting job", "inputs", inputs)
+	createInferenceEvent(job.ID, models.JobStateRunning, job.RayJobID, job.RetryCount, db)
 	setJobStatusAndID(job, models.JobStateRunning, job.RayJobID, "", db)
-	log.Printf("setting job %v to running\n", job.ID)
-	resp, err := ray.SubmitRayJob(*job, modelPath, job.RayJobID, inputs, db)
+	logger.Info("Job set to running")
+	resp, err := GetJobBackend(job.Model).Submit(ctx, job, inputs, db)
 	if err != nil {
+		observeSubmission(job, 0, err)
+		if isTransportError(err) {
+			logger.Warn("Backend could not be reached", "error", err)
+			return handleFailedSubmission(job, 0, []byte(err.Error()), db)
+		}
 		return err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
+	observeSubmission(job, resp.StatusCode, nil)
+	body := resp.Body
+
+	if state := fetchJobState(job.ID, db); state == models.JobStateStopped || state == models.JobStateFailed {
+		logger.Info("Job finished while being submitted, discarding response", "state", state)
+		return nil
 	}
 
 	if resp.StatusCode == http.StatusOK && job.JobType == models.JobTypeService {
 		var rayJobResponse models.RayJobResponse
 		rayJobResponse, err = UnmarshalRayJobResponse([]byte(body))
 		if err != nil {
-			fmt.Println("Error unmarshalling result JSON:", err)
+			logger.Error("Error unmarshalling result JSON", "error", err)
 			return err
 		}
 
-		fmt.Printf("Parsed Ray job response: %+v\n", rayJobResponse)
-		prettyJSON, err := PrettyPrintRayJobResponse(rayJobResponse)
-		if err != nil {
-			log.Fatalf("Error generating pretty JSON: %v", err)
+		if prettyJSON, err := PrettyPrintRayJobResponse(rayJobResponse); err == nil {
+			logger.Debug("Parsed Ray job response", "response", prettyJSON)
 		}
-
-		fmt.Printf("Parsed Ray job response:\n%s\n", prettyJSON)
-		completeRayJobAndAddFiles(job, body, rayJobResponse, db)
-		fmt.Printf("Job %v completed and added files to DB\n", job.ID)
+		completeRayJobAndAddFiles(ctx, job, body, rayJobResponse, db)
+		logger.Info("Job completed and added files to DB")
+		// The completion saved the job's state, saving it again would
+		// overwrite a concurrent stop
+		return nil
 	} else if resp.StatusCode != http.StatusOK {
-		createInferenceEvent(job.ID, models.JobStateFailed, job.RayJobID, 0, db)
-		// 504 - no retry, 404 - immediate retry once, 500 - exponential back off and retry twice
-		// TBD retry count and delay
-		if resp.StatusCode == http.StatusGatewayTimeout {
-			fmt.Printf("Job %v had timeout. Not retrying again. Marking as failed\n", job.ID)
-			job.JobStatus = models.JobStateFailed
-			err = db.Save(job).Error
-			if err != nil {
-				return err
-			}
-			return nil
-		} else if (resp.StatusCode == http.StatusNotFound) && job.RetryCount < maxRetryCountFor404 {
-			job.RetryCount = job.RetryCount + 1
-			job.JobStatus = models.JobStateProcessing
-			err = db.Save(job).Error
-			if err != nil {
-				return err
-			}
-			createInferenceEvent(job.ID, models.JobStateProcessing, job.RayJobID, job.RetryCount, db)
-
-			return submitRayJobAndUpdateID(job, db)
-		} else if (resp.StatusCode == http.StatusInternalServerError) && job.RetryCount < maxRetryCountFor500 {
-			job.RetryCount = job.RetryCount + 1
-			job.JobStatus = models.JobStateProcessing
-			err = db.Save(job).Error
-			if err != nil {
-				return err
-			}
-			createInferenceEvent(job.ID, models.JobStateProcessing, job.RayJobID, job.RetryCount, db)
-			// Calculate delay with exponential backoff and randomness
-			delay := getRandomDelay(job.RetryCount, 2*time.Second, 1.2, 500*time.Millisecond)
-
-			log.Printf("Retry after %v due to server error (500)", delay)
-			time.Sleep(delay)
-
-			return submitRayJobAndUpdateID(job, db)
-		} else {
-			var latestInferenceEvent models.InferenceEvent
-			err = fetchLatestInferenceEvent(&latestInferenceEvent, job.ID, db)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("The latest try of job %v had error %v. Retried %v times already. Marking as failed\n", job.ID, latestInferenceEvent.ResponseCode, job.RetryCount)
-			job.JobStatus = models.JobStateFailed
-			err = db.Save(job).Error
-			if err != nil {
-				return err
-			}
-		}
-
+		return handleFailedSubmission(job, resp.StatusCode, body, db)
 	}
-	fmt.Printf("Job had id %v\n", job.ID)
-	fmt.Printf("Finished Job with Ray id %v and status %v\n", job.RayJobID, job.JobStatus)
+	logger.Info("Finished job submission", "status", job.JobStatus)
 	err = db.Save(&job).Error
 	if err != nil {
 		return err
@@ -554,65 +934,80 @@ func createInferenceEvent(jobID uint, state models.JobState, rayJobID string, re
 	return nil
 }
 
-func completeRayJobAndAddFiles(job *models.Job, body []byte, resultJSON models.RayJobResponse, db *gorm.DB) error {
-
-	newInferenceEvent := models.InferenceEvent{
-		JobID:        job.ID,
-		EventTime:    time.Now().UTC(),
-		EventType:    models.EventTypeJobSucceeded,
-		JobStatus:    models.JobStateSucceeded,
-		ResponseCode: http.StatusOK,
-		OutputJson:   body,
-		RayJobID:     job.RayJobID,
-	}
-	if err := db.Save(&newInferenceEvent).Error; err != nil {
-		return fmt.Errorf("failed to save InferenceEvent: %v", err)
-	}
-	job.JobStatus = models.JobStateSucceeded
-	job.CompletedAt = time.Now().UTC()
-	if err := db.Save(&job).Error; err != nil {
-		return fmt.Errorf("failed to save Job: %v", err)
-	}
-
-	var user models.User
-	if err := db.First(&user, "wallet_address = ?", job.WalletAddress).Error; err != nil {
-		return fmt.Errorf("error fetching user: %v", err)
+func completeRayJobAndAddFiles(ctx context.Context, job *models.Job, body []byte, resultJSON models.RayJobResponse, db *gorm.DB) (err error) {
+	ctx, span := tracer.Start(ctx, "job.complete", trace.WithAttributes(
+		attribute.Int("job_id", int(job.ID)),
+		attribute.Int("files", len(resultJSON.Files)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Only a running job can succeed, a job that was stopped meanwhile
+	// keeps its state and is not billed
+	completedAt := time.Now().UTC()
+	result := db.Model(&models.Job{}).
+		Where("id = ? AND job_status = ?", job.ID, models.JobStateRunning).
+		Updates(map[string]interface{}{"job_status": models.JobStateSucceeded, "completed_at": completedAt})
+	if result.Error != nil {
+		return fmt.Errorf("failed to save Job: %v", result.Error)
 	}
+	succeeded := result.RowsAffected > 0
+
+	if succeeded {
+		job.JobStatus = models.JobStateSucceeded
+		job.CompletedAt = completedAt
+		newInferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			EventTime:    completedAt,
+			EventType:    models.EventTypeJobSucceeded,
+			JobStatus:    models.JobStateSucceeded,
+			ResponseCode: http.StatusOK,
+			OutputJson:   body,
+			RayJobID:     job.RayJobID,
+		}
+		if err := db.Save(&newInferenceEvent).Error; err != nil {
+			return fmt.Errorf("failed to save InferenceEvent: %v", err)
+		}
+		observeJobDuration(job, models.JobStateSucceeded)
 
-	if user.SubscriptionStatus == "active" {
-		points := resultJSON.Points
-		err := RecordUsage(user.StripeUserID, int64(points))
-		if err != nil {
-			return fmt.Errorf("error recording usage: %v", err)
+		if err := recordJobUsage(job, resultJSON.Points, db); err != nil {
+			return err
 		}
+	} else {
+		job.JobStatus = fetchJobState(job.ID, db)
+		jobLogger(job).Info("Job finished before its results came in, not marking it as succeeded", "state", job.JobStatus)
 	}
 
-	fmt.Printf("Looping through files in RayJobResponse\n %v\n", resultJSON.Files)
 	// Iterate over all files in the RayJobResponse
 	for key, fileDetail := range resultJSON.Files {
-		fmt.Printf("AddFileToDB for file: %s, Key: %s\n", fileDetail.URI, key)
-		if err := addFileToDB(job, fileDetail, key, db); err != nil {
+		if err := addFileToDB(ctx, job, fileDetail, key, db); err != nil {
 			return fmt.Errorf("failed to add file (%s) to database: %v", key, err)
 		}
 	}
 
-	fmt.Printf("Adding PDB file to DB\n %v\n", resultJSON.PDB)
 	// Special handling for PDB as it's a common file across many jobs
-	if err := addFileToDB(job, resultJSON.PDB, "pdb", db); err != nil {
+	if err := addFileToDB(ctx, job, resultJSON.PDB, "pdb", db); err != nil {
 		return fmt.Errorf("failed to add PDB file to database: %v", err)
 	}
 
 	return nil
 }
 
-func addFileToDB(job *models.Job, fileDetail models.FileDetail, fileType string, db *gorm.DB) error {
-	fmt.Printf("Processing file: %s, Type: %s\n", fileDetail.URI, fileType)
+func addFileToDB(ctx context.Context, job *models.Job, fileDetail models.FileDetail, fileType string, db *gorm.DB) (err error) {
+	_, span := tracer.Start(ctx, "addFileToDB", trace.WithAttributes(
+		attribute.Int("job_id", int(job.ID)),
+		attribute.String("file_type", fileType),
+		attribute.String("uri", fileDetail.URI),
+	))
+	defer func() { endSpan(span, err) }()
+
+	logger := jobLogger(job).With("uri", fileDetail.URI, "file_type", fileType)
+	logger.Debug("Processing file")
 
 	// Check if the file already exists
 	var file models.File
 	result := db.Where("s3_uri = ?", fileDetail.URI).First(&file)
 	if result.Error == nil {
-		fmt.Println("File already exists in DB:", fileDetail.URI)
+		logger.Debug("File already exists in DB")
 		return nil // File already processed
 	}
 
@@ -627,7 +1022,7 @@ func addFileToDB(job *models.Job, fileDetail models.FileDetail, fileType string,
 		{Name: "generated", Type: "autogenerated"},
 	}
 
-	fmt.Printf("Creating new File record for %s \n", fileDetail.URI)
+	logger.Info("Creating new File record")
 	file = models.File{
 		WalletAddress: job.WalletAddress,
 		Filename:      filepath.Base(fileDetail.URI),
@@ -650,22 +1045,14 @@ func addFileToDB(job *models.Job, fileDetail models.FileDetail, fileType string,
 }
 
 func processNewFiles(job *models.Job, db *gorm.DB) error {
-	bucketName := os.Getenv("BUCKET_NAME")
-	prefix := fmt.Sprintf("%s-", job.RayJobID) // Adjusted to the new naming pattern
-
-	s3client, err := s3client.NewS3Client()
-	if err != nil {
-		log.Printf("Error creating S3 client")
-	}
-
-	files, err := s3client.ListFilesInDirectory(bucketName, prefix)
+	files, err := GetJobBackend(job.Model).Outputs(job)
 	if err != nil {
 		return err
 	}
 
 	for _, fileName := range files {
 		if !fileProcessed(fileName, job.ID, db) {
-			if err := processFile(fileName, job, db); err != nil { // Function to process the file
+			if err := processFile(jobContext(context.Background(), job), fileName, job, db); err != nil { // Function to process the file
 				return err
 			}
 		}
@@ -673,27 +1060,38 @@ func processNewFiles(job *models.Job, db *gorm.DB) error {
 	return nil
 }
 
-func processFile(fileName string, job *models.Job, db *gorm.DB) error {
-	// Get the content of the file from S3 using the GetRayJobResponseFromS3 function
-	data := GetRayJobResponseFromS3(fileName, job, db)
+func processFile(ctx context.Context, fileName string, job *models.Job, db *gorm.DB) (err error) {
+	ctx, span := tracer.Start(ctx, "job.process_output", trace.WithAttributes(
+		attribute.Int("job_id", int(job.ID)),
+		attribute.String("file", fileName),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Get the content of the response document from the job's backend
+	data, err := GetJobBackend(job.Model).ReadOutput(ctx, job, fileName, db)
+	if err != nil {
+		return err
+	}
 	if len(data) == 0 {
-		log.Printf("Failed to get or empty data from file %s for job %d", fileName, job.ID)
+		jobLogger(job).Error("Failed to get or empty data from file", "file", fileName)
 		return fmt.Errorf("empty data received from S3 for file %s", fileName)
 	}
 
 	rayJobResponse, err := UnmarshalRayJobResponse(data)
 	if err != nil {
-		fmt.Println("Error unmarshalling result JSON:", err)
+		jobLogger(job).Error("Error unmarshalling result JSON", "file", fileName, "error", err)
 		return err
 	}
 
 	// Add files and update related job data in the database without marking the job as completed
-	if err := addFilesAndUpdateJob(job, data, rayJobResponse, db); err != nil {
-		log.Printf("Failed to add files and update job for job %d from file %s: %v", job.ID, fileName, err)
+	if err := addFilesAndUpdateJob(ctx, job, data, rayJobResponse, db); err != nil {
+		jobLogger(job).Error("Failed to add files and update job", "file", fileName, "error", err)
 		return err
 	}
 
-	// Mark this file as processed in the inference event table
+	// Mark this file as processed in the inference event table. The job row
+	// stays locked meanwhile, so a job is either stopped before the file is
+	// recorded, and not billed for it, or after.
 	event := models.InferenceEvent{ //add job status too
 		JobID:      job.ID,
 		RayJobID:   job.RayJobID,
@@ -703,24 +1101,39 @@ func processFile(fileName string, job *models.Job, db *gorm.DB) error {
 		EventTime:  time.Now(),
 		OutputJson: datatypes.JSON(data), // Storing the JSON output directly in the event
 	}
-	if err := db.Create(&event).Error; err != nil {
-		log.Printf("Failed to record file processing event for job %d: %v", job.ID, err)
+	var running bool
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var current models.Job
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "job_status").First(&current, job.ID).Error; err != nil {
+			return err
+		}
+		running = current.JobStatus == models.JobStateRunning
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		jobLogger(job).Error("Failed to record file processing event", "file", fileName, "error", err)
 		return err
 	}
 
+	if !running {
+		return nil
+	}
+	return recordJobUsage(job, rayJobResponse.Points, db)
+}
+
+// recordJobUsage bills the points of a job's results to its user, if they
+// pay per use.
+func recordJobUsage(job *models.Job, points int, db *gorm.DB) error {
 	var user models.User
 	if err := db.First(&user, "wallet_address = ?", job.WalletAddress).Error; err != nil {
 		return fmt.Errorf("error fetching user: %v", err)
 	}
-
-	if user.SubscriptionStatus == "active" {
-		points := rayJobResponse.Points
-		err := RecordUsage(user.StripeUserID, int64(points))
-		if err != nil {
-			return fmt.Errorf("error recording usage: %v", err)
-		}
+	if user.SubscriptionStatus != "active" {
+		return nil
+	}
+	if err := RecordUsage(user.StripeUserID, int64(points)); err != nil {
+		return fmt.Errorf("error recording usage: %v", err)
 	}
-
 	return nil
 }
 
@@ -730,12 +1143,12 @@ func fileProcessed(fileName string, jobID uint, db *gorm.DB) bool {
 	return count > 0
 }
 
-func addFilesAndUpdateJob(job *models.Job, data []byte, response models.RayJobResponse, db *gorm.DB) error {
-	fmt.Printf("Adding output files and updating job data for job %d\n", job.ID)
+func addFilesAndUpdateJob(ctx context.Context, job *models.Job, data []byte, response models.RayJobResponse, db *gorm.DB) error {
+	jobLogger(job).Info("Adding output files and updating job data", "files", len(response.Files))
 
 	// Loop through the files detailed in the response
 	for key, fileDetail := range response.Files {
-		if err := addFileToDB(job, fileDetail, key, db); err != nil {
+		if err := addFileToDB(ctx, job, fileDetail, key, db); err != nil {
 			return fmt.Errorf("failed to add file (%s) to database: %v", key, err)
 		}
 	}
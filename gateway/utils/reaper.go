@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"gorm.io/gorm"
+)
+
+// ReapTimedOutJobs periodically fails every job that has been running for
+// longer than its model's MaxRunningTime, until ctx is cancelled.
+func ReapTimedOutJobs(ctx context.Context, db *gorm.DB) error {
+	for {
+		if err := reapTimedOutJobs(db); err != nil {
+			slog.Error("Error reaping timed out jobs", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(seconds(settings.Queue.ReaperIntervalSeconds)):
+		}
+	}
+}
+
+func reapTimedOutJobs(db *gorm.DB) error {
+	var jobs []models.Job
+	err := db.Preload("Model").
+		Select("jobs.*").
+		Joins("JOIN models ON models.id = jobs.model_id").
+		Where("jobs.job_status IN ?", []models.JobState{models.JobStatePending, models.JobStateRunning}).
+		Where("jobs.started_at > ?", time.Time{}).
+		Where("models.max_running_time > 0").
+		Where("jobs.started_at < NOW() - models.max_running_time * INTERVAL '1 second'").
+		Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := timeOutJob(&job, db); err != nil {
+			jobLogger(&job).Error("Error timing out job", "error", err)
+		}
+	}
+	return nil
+}
+
+func timeOutJob(job *models.Job, db *gorm.DB) error {
+	jobLogger(job).Warn("Job exceeded its max running time, stopping it", "max_running_time_seconds", job.Model.MaxRunningTime)
+	// Local processes can only be stopped by the process that started them,
+	// its monitor picks the cancellation up like the one of CancelJob
+	cancelPending := job.RayJobID != "" && runsInProcess(job) && job.ClaimedBy != InstanceID
+	backendStop := "it was not submitted to its backend yet"
+	switch {
+	case cancelPending:
+		backendStop = "stopping it is left to the instance running it"
+	case job.RayJobID != "":
+		err := GetJobBackend(job.Model).Cancel(job)
+		switch {
+		case err == nil:
+			backendStop = "its backend confirmed the stop"
+		case errors.Is(err, ErrBackendJobNotFound):
+			// A wrong or stale Ray job ID would leave the real job running
+			jobLogger(job).Warn("Backend does not know the timed out job, the stop is not confirmed")
+			backendStop = "its backend did not find it, the stop is not confirmed"
+		default:
+			return fmt.Errorf("error stopping job in backend: %v", err)
+		}
+	}
+
+	message := fmt.Sprintf("Job timed out after exceeding the max running time of %v seconds", job.Model.MaxRunningTime)
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Job{}).
+			Where("id = ? AND job_status IN ?", job.ID, []models.JobState{models.JobStatePending, models.JobStateRunning}).
+			Updates(map[string]interface{}{
+				"job_status":     models.JobStateFailed,
+				"error":          message,
+				"completed_at":   time.Now().UTC(),
+				"cancel_pending": cancelPending,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// The job finished on its own in the meantime
+			return nil
+		}
+		observeJobDuration(job, models.JobStateFailed)
+
+		inferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			RayJobID:     job.RayJobID,
+			RetryCount:   job.RetryCount,
+			JobStatus:    models.JobStateFailed,
+			EventTime:    time.Now().UTC(),
+			EventType:    models.EventTypeJobTimedOut,
+			EventMessage: message + ", " + backendStop,
+		}
+		return tx.Create(&inferenceEvent).Error
+	})
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a database with the tables the queue uses. It is SQLite,
+// so queries that only Postgres understands are not covered.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "plex.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Model{}, &models.Job{}, &models.InferenceEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createRunningJob(t *testing.T, db *gorm.DB, queueType models.QueueType, claimedBy string) *models.Job {
+	t.Helper()
+	model := models.Model{Name: "model", WalletAddress: "0x0", QueueType: queueType, MaxRunningTime: 60}
+	if err := db.Create(&model).Error; err != nil {
+		t.Fatal(err)
+	}
+	job := models.Job{
+		RayJobID:     "job-1",
+		JobStatus:    models.JobStateRunning,
+		ExperimentID: 1,
+		ModelID:      model.ID,
+		ClaimedBy:    claimedBy,
+		StartedAt:    time.Now().Add(-time.Hour),
+	}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	job.Model = model
+	return &job
+}
+
+func TestTimeOutJob(t *testing.T) {
+	cases := map[string]struct {
+		claimedBy     string
+		cancelPending bool
+		event         string
+	}{
+		// The exec backend of this process does not know the job, only the
+		// instance that started it can stop it
+		"exec job of another instance": {"other-instance", true, "stopping it is left to the instance running it"},
+		"exec job of this instance":    {InstanceID, false, "its backend did not find it, the stop is not confirmed"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			job := createRunningJob(t, db, models.QueueTypeExec, c.claimedBy)
+			if err := timeOutJob(job, db); err != nil {
+				t.Fatal(err)
+			}
+
+			var reaped models.Job
+			if err := db.First(&reaped, job.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if reaped.JobStatus != models.JobStateFailed {
+				t.Errorf("job status = %s, want failed", reaped.JobStatus)
+			}
+			if reaped.CancelPending != c.cancelPending {
+				t.Errorf("cancel pending = %v, want %v", reaped.CancelPending, c.cancelPending)
+			}
+			var events []models.InferenceEvent
+			db.Where("job_id = ? AND event_type = ?", job.ID, models.EventTypeJobTimedOut).Find(&events)
+			if len(events) != 1 || !strings.HasSuffix(events[0].EventMessage, c.event) {
+				t.Errorf("got timed out events %+v, want one ending in %q", events, c.event)
+			}
+		})
+	}
+}
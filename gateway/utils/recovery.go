@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labdao/plex/gateway/models"
+	"gorm.io/gorm"
+)
+
+// InstanceID identifies this gateway process as the owner of the jobs it
+// claims from the queue. Configuring GATEWAY_INSTANCE_ID to a stable value
+// lets a restarted gateway recover its own jobs right away instead of waiting
+// for their leases to expire.
+var InstanceID = newInstanceID()
+
+func jobLeaseDuration() time.Duration {
+	return seconds(settings.Queue.JobLeaseSeconds)
+}
+
+// claimedJobStates are the states in which a job depends on the gateway
+// instance that claimed it. Batch jobs that made it to running are watched by
+// MonitorRunningJobs instead, but service jobs wait for their response in
+// the worker.
+var claimedJobStates = []models.JobState{models.JobStateProcessing, models.JobStatePending, models.JobStateRunning}
+
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gateway"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])
+}
+
+// ReconcileJobsOnStartup recovers the jobs a previous gateway process left
+// behind. It has to run before the queue workers start claiming jobs.
+func ReconcileJobsOnStartup(db *gorm.DB) error {
+	return recoverOrphanedJobs(db, true)
+}
+
+// MaintainJobLeases renews the leases of the jobs this instance holds and
+// periodically recovers jobs whose owner stopped renewing theirs, until ctx
+// is cancelled.
+func MaintainJobLeases(ctx context.Context, db *gorm.DB) error {
+	heartbeat := time.NewTicker(jobLeaseDuration() / 3)
+	defer heartbeat.Stop()
+	reconcile := time.NewTicker(jobLeaseDuration())
+	defer reconcile.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := renewJobLeases(db); err != nil {
+				slog.Error("Error renewing job leases", "instance_id", InstanceID, "error", err)
+			}
+		case <-reconcile.C:
+			if err := recoverOrphanedJobs(db, false); err != nil {
+				slog.Error("Error recovering orphaned jobs", "error", err)
+			}
+		}
+	}
+}
+
+// ReleaseClaimedJobs hands the jobs this instance still holds at shutdown
+// back to the queue, or to the monitor if their batch submission went
+// through, so that other instances don't have to wait for the leases to
+// expire. It must only run once the queue workers stopped.
+func ReleaseClaimedJobs(db *gorm.DB) error {
+	return recoverOrphanedJobs(db, true)
+}
+
+func renewJobLeases(db *gorm.DB) error {
+	return db.Model(&models.Job{}).
+		Where("claimed_by = ? AND job_status IN ?", InstanceID, claimedJobStates).
+		Update("lease_expires_at", time.Now().UTC().Add(jobLeaseDuration())).Error
+}
+
+// orphanedJobs selects claimed jobs whose owner stopped renewing their lease.
+// At startup, jobs still claimed under our own instance ID are orphans too.
+func orphanedJobs(db *gorm.DB, startup bool) *gorm.DB {
+	now := time.Now().UTC()
+	claimed := db.Session(&gorm.Session{NewDB: true}).
+		Where("job_status IN ?", []models.JobState{models.JobStateProcessing, models.JobStatePending}).
+		Or("job_status = ? AND job_type = ?", models.JobStateRunning, models.JobTypeService)
+	if startup {
+		return db.Where(claimed).Where("lease_expires_at IS NULL OR lease_expires_at < ? OR claimed_by = ?", now, InstanceID)
+	}
+	return db.Where(claimed).Where("lease_expires_at IS NULL OR lease_expires_at < ?", now)
+}
+
+func recoverOrphanedJobs(db *gorm.DB, startup bool) error {
+	var jobs []models.Job
+	if err := orphanedJobs(db.Preload("Model"), startup).Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := recoverOrphanedJob(&job, startup, db); err != nil {
+			jobLogger(&job).Error("Error recovering orphaned job", "error", err)
+		}
+	}
+	return nil
+}
+
+// recoverOrphanedJob re-attaches a job to its batch submission if the backend
+// knows about it, and otherwise puts it back into the queue.
+func recoverOrphanedJob(job *models.Job, startup bool, db *gorm.DB) error {
+	if job.RayJobID != "" && job.JobType != models.JobTypeService {
+		_, err := GetJobBackend(job.Model).Status(job)
+		if err == nil {
+			message := fmt.Sprintf("Re-attached to Ray job %v after its worker stopped responding", job.RayJobID)
+			updates := map[string]interface{}{"job_status": models.JobStateRunning, "claimed_by": ""}
+			if job.StartedAt.IsZero() {
+				updates["started_at"] = time.Now().UTC()
+			}
+			return updateOrphanedJob(job, startup, updates, models.JobStateRunning, models.EventTypeJobRunning, message, db)
+		} else if !errors.Is(err, ErrBackendJobNotFound) {
+			return err
+		}
+	}
+
+	message := "Re-queued after its worker stopped responding"
+	updates := map[string]interface{}{
+		"job_status":      models.JobStateQueued,
+		"ray_job_id":      "",
+		"claimed_by":      "",
+		"next_attempt_at": time.Now().UTC(),
+	}
+	return updateOrphanedJob(job, startup, updates, models.JobStateQueued, models.EventTypeJobQueued, message, db)
+}
+
+func updateOrphanedJob(job *models.Job, startup bool, updates map[string]interface{}, state models.JobState, eventType string, message string, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Only touch the job if it is still orphaned in the state we saw
+		result := orphanedJobs(tx.Model(&models.Job{}), startup).
+			Where("id = ? AND job_status = ?", job.ID, job.JobStatus).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		jobLogger(job).Info(message, "state", state)
+
+		inferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			RayJobID:     job.RayJobID,
+			RetryCount:   job.RetryCount,
+			JobStatus:    state,
+			EventTime:    time.Now().UTC(),
+			EventType:    eventType,
+			EventMessage: message,
+		}
+		return tx.Create(&inferenceEvent).Error
+	})
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/internal/ipwl"
+	"gorm.io/gorm"
+)
+
+// retryPolicy is a model's retryPolicy merged over the defaults.
+type retryPolicy struct {
+	StatusCodes           []int
+	MaxRetries            int
+	InitialBackoffSeconds float64
+	BackoffMultiplier     float64
+	// StatusMaxRetries caps the retries of some of the StatusCodes below
+	// MaxRetries.
+	StatusMaxRetries map[int]int
+}
+
+// defaultRetryPolicy applies to models that do not define a retryPolicy of
+// their own. Gateway timeouts are not retried, the job would likely time out
+// again. 404s usually mean the model's endpoint is missing and are only
+// retried MAX_RETRY_COUNT_FOR_404 times.
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		StatusCodes:           []int{404, 500, 502, 503},
+		MaxRetries:            settings.Queue.MaxRetries,
+		InitialBackoffSeconds: 2,
+		BackoffMultiplier:     1.2,
+		StatusMaxRetries:      map[int]int{404: settings.Queue.MaxRetriesFor404},
+	}
+}
+
+// retryPolicyForModel merges the model's retryPolicy over the defaults.
+func retryPolicyForModel(model models.Model) retryPolicy {
+	policy := defaultRetryPolicy()
+
+	var modelJson ipwl.Model
+	if err := json.Unmarshal(model.ModelJson, &modelJson); err != nil || modelJson.RetryPolicy == nil {
+		return policy
+	}
+	if modelJson.RetryPolicy.StatusCodes != nil {
+		// Status codes a model lists are retried up to maxRetries
+		policy.StatusCodes = modelJson.RetryPolicy.StatusCodes
+		policy.StatusMaxRetries = nil
+	}
+	if modelJson.RetryPolicy.MaxRetries != nil {
+		policy.MaxRetries = *modelJson.RetryPolicy.MaxRetries
+	}
+	if modelJson.RetryPolicy.InitialBackoffSeconds != nil {
+		policy.InitialBackoffSeconds = *modelJson.RetryPolicy.InitialBackoffSeconds
+	}
+	if modelJson.RetryPolicy.BackoffMultiplier != nil {
+		policy.BackoffMultiplier = *modelJson.RetryPolicy.BackoffMultiplier
+	}
+	return policy
+}
+
+// isTransportError reports whether a submission failed because the backend
+// could not be reached, e.g. the connection was refused or timed out.
+// Submissions cut short by a shutdown are left to the job recovery.
+func isTransportError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && !errors.Is(err, context.Canceled)
+}
+
+// shouldRetry reports whether a failed submission is retried. A statusCode of
+// 0 stands for a transport error, which is always worth retrying.
+func shouldRetry(policy retryPolicy, statusCode int, retryCount int) bool {
+	if retryCount >= policy.MaxRetries {
+		return false
+	}
+	if statusCode == 0 {
+		return true
+	}
+	for _, code := range policy.StatusCodes {
+		if code == statusCode {
+			limit, limited := policy.StatusMaxRetries[statusCode]
+			return !limited || retryCount < limit
+		}
+	}
+	return false
+}
+
+// failureReason describes why a submission failed, statusCode 0 being a
+// transport error.
+func failureReason(statusCode int) string {
+	if statusCode == 0 {
+		return "without a response"
+	}
+	return fmt.Sprintf("with status %v", statusCode)
+}
+
+// handleFailedSubmission either puts a job whose submission was rejected back
+// into the queue for a later attempt or marks it as failed. Nothing waits in
+// the worker, the queue picks the job up again once NextAttemptAt has passed.
+func handleFailedSubmission(job *models.Job, statusCode int, body []byte, db *gorm.DB) error {
+	policy := retryPolicyForModel(job.Model)
+	if !shouldRetry(policy, statusCode, job.RetryCount) {
+		jobLogger(job).Warn("Job submission failed, marking as failed", "status_code", statusCode, "retry_count", job.RetryCount)
+		message := fmt.Sprintf("Job submission failed %s after %v retries", failureReason(statusCode), job.RetryCount)
+		if len(body) > 0 {
+			message = fmt.Sprintf("%s: %s", message, TailLines(string(body), settings.Queue.ErrorLogLines))
+		}
+		return failJobSubmission(job, statusCode, message, db)
+	}
+
+	delay := getRandomDelay(job.RetryCount, time.Duration(policy.InitialBackoffSeconds*float64(time.Second)), policy.BackoffMultiplier, 500*time.Millisecond)
+	if delay < 0 {
+		delay = 0
+	}
+	nextAttemptAt := time.Now().UTC().Add(delay)
+	retryCount := job.RetryCount + 1
+	message := fmt.Sprintf("Submission failed %s, retry %v of %v scheduled at %v", failureReason(statusCode), retryCount, policy.MaxRetries, nextAttemptAt.Format(time.RFC3339))
+	jobLogger(job).Info("Job submission failed, retry scheduled", "status_code", statusCode, "retry_count", retryCount, "next_attempt_at", nextAttemptAt)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// A fresh Ray job ID is assigned when the job is picked up again
+		result := tx.Model(&models.Job{}).
+			Where("id = ? AND job_status = ?", job.ID, models.JobStateRunning).
+			Updates(map[string]interface{}{
+				"job_status":      models.JobStateQueued,
+				"retry_count":     retryCount,
+				"ray_job_id":      "",
+				"next_attempt_at": nextAttemptAt,
+				"claimed_by":      "",
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// The job was cancelled or timed out in the meantime
+			return nil
+		}
+		jobRetries.WithLabelValues(metricsModelName(job.Model), strconv.Itoa(statusCode)).Inc()
+
+		inferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			RayJobID:     job.RayJobID,
+			RetryCount:   retryCount,
+			JobStatus:    models.JobStateQueued,
+			ResponseCode: statusCode,
+			EventTime:    time.Now().UTC(),
+			EventType:    models.EventTypeJobRetryScheduled,
+			EventMessage: message,
+		}
+		return tx.Create(&inferenceEvent).Error
+	})
+}
+
+func failJobSubmission(job *models.Job, statusCode int, message string, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Job{}).
+			Where("id = ? AND job_status = ?", job.ID, models.JobStateRunning).
+			Updates(map[string]interface{}{
+				"job_status":   models.JobStateFailed,
+				"error":        message,
+				"completed_at": time.Now().UTC(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		job.JobStatus = models.JobStateFailed
+		job.Error = message
+		observeJobDuration(job, models.JobStateFailed)
+
+		inferenceEvent := models.InferenceEvent{
+			JobID:        job.ID,
+			RayJobID:     job.RayJobID,
+			RetryCount:   job.RetryCount,
+			JobStatus:    models.JobStateFailed,
+			ResponseCode: statusCode,
+			EventTime:    time.Now().UTC(),
+			EventType:    models.EventTypeJobFailed,
+			EventMessage: message,
+		}
+		return tx.Create(&inferenceEvent).Error
+	})
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/labdao/plex/gateway/models"
+)
+
+func TestShouldRetry(t *testing.T) {
+	defaults := models.Model{}
+	ownCodes := models.Model{ModelJson: []byte(`{"retryPolicy": {"statusCodes": [404], "maxRetries": 3}}`)}
+	cases := map[string]struct {
+		model      models.Model
+		statusCode int
+		retryCount int
+		retry      bool
+	}{
+		"500":                     {defaults, 500, 1, true},
+		"500 out of retries":      {defaults, 500, 2, false},
+		"404 once":                {defaults, 404, 0, true},
+		"404 twice":               {defaults, 404, 1, false},
+		"504":                     {defaults, 504, 0, false},
+		"no response":             {defaults, 0, 1, true},
+		"404 listed by the model": {ownCodes, 404, 2, true},
+		"500 not listed":          {ownCodes, 500, 0, false},
+	}
+	for name, c := range cases {
+		if retry := shouldRetry(retryPolicyForModel(c.model), c.statusCode, c.retryCount); retry != c.retry {
+			t.Errorf("%s: got %v, want %v", name, retry, c.retry)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"time"
 
@@ -10,18 +9,18 @@ import (
 	"github.com/stripe/stripe-go/v78/billing/meterevent"
 )
 
+// setupStripeClient checks that Configure set the Stripe API key.
 func setupStripeClient() error {
-	apiKey := os.Getenv("STRIPE_SECRET_KEY")
-	if apiKey == "" {
-		return fmt.Errorf("STRIPE_SECRET_KEY environment variable not set")
+	if stripe.Key == "" {
+		return fmt.Errorf("STRIPE_SECRET_KEY is not configured")
 	}
-	stripe.Key = apiKey
 	return nil
 }
 
 func RecordUsage(stripeCustomerID string, usage int64) error {
 	err := setupStripeClient()
 	if err != nil {
+		stripeUsageRecordFailures.Inc()
 		return fmt.Errorf("failed to set up Stripe client: %v", err)
 	}
 
@@ -35,6 +34,7 @@ func RecordUsage(stripeCustomerID string, usage int64) error {
 	}
 	_, err = meterevent.New(params)
 	if err != nil {
+		stripeUsageRecordFailures.Inc()
 		return fmt.Errorf("failed to record usage: %v", err)
 	}
 	return nil
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/labdao/plex/gateway/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/labdao/plex/gateway/utils")
+
+// Traceparent returns the W3C traceparent of the current span of ctx, or ""
+// if there is none. Jobs store it to continue the trace of their request.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// jobContext returns a context that continues the trace of the request that
+// created the job, so work done by workers and the monitor joins it.
+func jobContext(ctx context.Context, job *models.Job) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{"traceparent": job.TraceParent})
+}
+
+// recordQueueWait adds a span for the time a job spent queued, from creation
+// or from its last retry until it was claimed.
+func recordQueueWait(ctx context.Context, job *models.Job) {
+	waitStart := job.CreatedAt
+	if job.NextAttemptAt.After(waitStart) {
+		waitStart = job.NextAttemptAt
+	}
+	_, span := tracer.Start(ctx, "queue.wait",
+		trace.WithTimestamp(waitStart),
+		trace.WithAttributes(attribute.Int("job_id", int(job.ID)), attribute.Int("retry_count", job.RetryCount)),
+	)
+	span.End(trace.WithTimestamp(time.Now()))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
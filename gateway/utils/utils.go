@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
@@ -40,7 +40,17 @@ func SendJSONError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(map[string]string{"message": message}); err != nil {
-		log.Printf("Could not encode JSON: %v", err)
+		slog.Error("Could not encode JSON", "error", err)
+	}
+}
+
+// SendJSONErrorWithDetails is SendJSONError with a list of problems, e.g. one
+// per invalid field, next to the message.
+func SendJSONErrorWithDetails(w http.ResponseWriter, message string, details interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"message": message, "errors": details}); err != nil {
+		slog.Error("Could not encode JSON", "error", err)
 	}
 }
 
@@ -122,7 +132,7 @@ func GetEnvAsInt(name string, defaultValue int) int {
 	}
 	value, err := strconv.Atoi(valueStr)
 	if err != nil {
-		fmt.Printf("Warning: Invalid format for %s. Using default value. \n", name)
+		slog.Warn("Invalid format for environment variable, using default value", "name", name, "default", defaultValue)
 		return defaultValue
 	}
 	return value
@@ -136,3 +146,13 @@ func Contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+// TailLines returns the last n lines of text, or all of it if n is 0 or
+// larger than the number of lines.
+func TailLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if n <= 0 || n >= len(lines) {
+		return text
+	}
+	return strings.Join(lines[len(lines)-n:], "\n") + "\n"
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -19,8 +19,6 @@ import (
 	"gorm.io/gorm"
 )
 
-var autotaskWebhook = os.Getenv("AUTOTASK_WEBHOOK")
-
 var rateLimiter = NewTokenBucketRateLimiter(1, 1)
 
 type TokenBucketRateLimiter struct {
@@ -68,6 +66,7 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 		return "", fmt.Errorf("failed to retrieve jobs: %v", err)
 	}
 
+	logger := slog.With("experiment_id", experiment.ID)
 	metadata := map[string]interface{}{
 		"name":        experiment.Name,
 		"description": "Research, Reimagined. All Scientists Welcome.",
@@ -101,19 +100,19 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 			"errMsg":  job.Error,
 		}
 
-		log.Printf("Pinning model JSON to IPFS: %s", model.Name)
+		logger.Info("Pinning model JSON to IPFS", "model", model.Name)
 		modelPinataHash, err := pinJSONToPublicIPFS(json.RawMessage(model.ModelJson), model.Name)
 		if err != nil {
-			log.Printf("Failed to pin model JSON to Pinata: %s. Skipping... Error: %v", model.Name, err)
+			logger.Warn("Error pinning model JSON to Pinata, skipping", "model", model.Name, "error", err)
 		} else {
-			log.Printf("Pinned model JSON to public IPFS with CID: %s", modelPinataHash)
+			logger.Info("Pinned model JSON to public IPFS", "model", model.Name, "cid", modelPinataHash)
 			ioObject["model"] = map[string]interface{}{
 				"cid": modelPinataHash,
 			}
 		}
 		s3c, err := s3.NewS3Client()
 		for _, inputFile := range inputFiles {
-			log.Printf("Downloading input file: %s", inputFile.Filename)
+			logger.Info("Downloading input file", "filename", inputFile.Filename)
 			// inputTempFilePath, err := ipfs.DownloadFileToTemp(inputFile.CID, inputFile.Filename)
 			if err != nil {
 				return "", fmt.Errorf("failed to create S3 client: %v", err)
@@ -129,12 +128,12 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 			}
 			defer os.Remove(fileName)
 
-			log.Printf("Pinning input file to IPFS: %s", inputFile.Filename)
+			logger.Info("Pinning input file to IPFS", "filename", inputFile.Filename)
 			inputPinataHash, err := pinFileToPublicIPFS(key, inputFile.Filename)
 			if err != nil {
-				log.Printf("Failed to pin input file to Pinata: %s. Skipping... Error: %v", inputFile.Filename, err)
+				logger.Warn("Error pinning input file to Pinata, skipping", "filename", inputFile.Filename, "error", err)
 			} else {
-				log.Printf("Pinned input file to public IPFS with CID: %s", inputPinataHash)
+				logger.Info("Pinned input file to public IPFS", "filename", inputFile.Filename, "cid", inputPinataHash)
 				ioObject["inputs"] = append(ioObject["inputs"].([]map[string]interface{}), map[string]interface{}{
 					"cid":      inputPinataHash,
 					"filename": inputFile.Filename,
@@ -143,7 +142,7 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 		}
 
 		for _, outputFile := range outputFiles {
-			log.Printf("Downloading output file: %s", outputFile.Filename)
+			logger.Info("Downloading output file", "filename", outputFile.Filename)
 			// outputTempFilePath, err := ipfs.DownloadFileToTemp(outputFile.CID, outputFile.Filename)
 			bucket, key, err := s3c.GetBucketAndKeyFromURI(outputFile.S3URI)
 			if err != nil {
@@ -152,17 +151,17 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 			fileName := filepath.Base(key)
 			err = s3c.DownloadFile(bucket, key, fileName)
 			if err != nil {
-				log.Printf("Failed to download output file: %s. Skipping... Error: %v", outputFile.Filename, err)
+				logger.Warn("Error downloading output file, skipping", "filename", outputFile.Filename, "error", err)
 				continue
 			}
 			defer os.Remove(fileName)
 
-			log.Printf("Pinning output file to IPFS: %s", outputFile.Filename)
+			logger.Info("Pinning output file to IPFS", "filename", outputFile.Filename)
 			outputPinataHash, err := pinFileToPublicIPFS(key, outputFile.Filename)
 			if err != nil {
-				log.Printf("Failed to pin output file to Pinata: %s. Skipping... Error: %v", outputFile.Filename, err)
+				logger.Warn("Error pinning output file to Pinata, skipping", "filename", outputFile.Filename, "error", err)
 			} else {
-				log.Printf("Pinned output file to public IPFS with CID: %s", outputPinataHash)
+				logger.Info("Pinned output file to public IPFS", "filename", outputFile.Filename, "cid", outputPinataHash)
 				ioObject["outputs"] = append(ioObject["outputs"].([]map[string]interface{}), map[string]interface{}{
 					"cid":      outputPinataHash,
 					"filename": outputFile.Filename,
@@ -189,7 +188,7 @@ func BuildTokenMetadata(db *gorm.DB, experiment *models.Experiment) (string, err
 		return "", fmt.Errorf("failed to marshal metadata: %v", err)
 	}
 
-	log.Printf("Token Metadata: %s", metadataJSON)
+	logger.Debug("Built token metadata", "metadata", string(metadataJSON))
 
 	return string(metadataJSON), nil
 }
@@ -215,7 +214,7 @@ func pinJSONToPublicIPFS(jsonData json.RawMessage, name string) (string, error)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("PINATA_API_TOKEN"))
+	req.Header.Set("Authorization", "Bearer "+settings.Web3.PinataAPIToken)
 
 	client := &http.Client{}
 
@@ -227,7 +226,7 @@ func pinJSONToPublicIPFS(jsonData json.RawMessage, name string) (string, error)
 
 		resp, err := client.Do(req)
 		if err != nil {
-			log.Printf("Error sending request to Pinata API: %v", err)
+			slog.Warn("Error sending request to Pinata API, retrying", "name", name, "error", err)
 			time.Sleep(retryDelay)
 			retryDelay *= 2
 			continue
@@ -235,7 +234,7 @@ func pinJSONToPublicIPFS(jsonData json.RawMessage, name string) (string, error)
 		defer resp.Body.Close()
 
 		if resp.StatusCode == http.StatusTooManyRequests {
-			log.Println("Rate limit exceeded. Retrying after a delay...")
+			slog.Warn("Pinata rate limit exceeded, retrying", "name", name, "delay", retryDelay)
 			time.Sleep(retryDelay)
 			retryDelay *= 2
 			continue
@@ -297,7 +296,7 @@ func pinFileToPublicIPFS(filePath, name string) (string, error) {
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("PINATA_API_TOKEN"))
+	req.Header.Set("Authorization", "Bearer "+settings.Web3.PinataAPIToken)
 
 	client := &http.Client{}
 
@@ -309,7 +308,7 @@ func pinFileToPublicIPFS(filePath, name string) (string, error) {
 
 		resp, err := client.Do(req)
 		if err != nil {
-			log.Printf("Error sending request to Pinata API: %v", err)
+			slog.Warn("Error sending request to Pinata API, retrying", "name", name, "error", err)
 			time.Sleep(retryDelay)
 			retryDelay *= 2
 			continue
@@ -317,7 +316,7 @@ func pinFileToPublicIPFS(filePath, name string) (string, error) {
 		defer resp.Body.Close()
 
 		if resp.StatusCode == http.StatusTooManyRequests {
-			log.Println("Rate limit exceeded. Retrying after a delay...")
+			slog.Warn("Pinata rate limit exceeded, retrying", "name", name, "delay", retryDelay)
 			time.Sleep(retryDelay)
 			retryDelay *= 2
 			continue
@@ -343,36 +342,33 @@ func pinFileToPublicIPFS(filePath, name string) (string, error) {
 }
 
 func GenerateAndStoreRecordCID(db *gorm.DB, experiment *models.Experiment) (string, error) {
-	log.Println("Generating token metadata...")
+	logger := slog.With("experiment_id", experiment.ID)
+	logger.Info("Generating token metadata")
 	metadataJSON, err := BuildTokenMetadata(db, experiment)
 	if err != nil {
 		return "", fmt.Errorf("failed to build token metadata: %v", err)
 	}
-	log.Println("Generated token metadata.")
-
-	log.Println("Pinning token metadata to IPFS...")
+	logger.Info("Pinning token metadata to IPFS")
 	metadataCID, err := pinJSONToPublicIPFS(json.RawMessage(metadataJSON), experiment.Name+"_record_metadata.json")
 	if err != nil {
 		return "", fmt.Errorf("failed to pin token metadata to Pinata: %v", err)
 	}
-	log.Printf("Pinned token metadata to public IPFS with CID: %s", metadataCID)
+	logger.Info("Pinned token metadata to public IPFS", "cid", metadataCID)
 
-	log.Println("Updating Experiment's RecordCID...")
 	experiment.RecordCID = metadataCID
 	if err := db.Save(experiment).Error; err != nil {
 		return "", fmt.Errorf("failed to update Experiment's RecordCID: %v", err)
 	}
-	log.Println("Updated Experiment's RecordCID.")
-
 	return metadataCID, nil
 }
 
 func MintNFT(db *gorm.DB, experiment *models.Experiment, metadataCID string) error {
+	autotaskWebhook := settings.Web3.AutotaskWebhook
 	if autotaskWebhook == "" {
 		return fmt.Errorf("AUTOTASK_WEBHOOK must be set")
 	}
 
-	log.Println("Triggering minting process via Defender Autotask...")
+	slog.Info("Triggering minting process via Defender Autotask", "experiment_id", experiment.ID)
 
 	data := postData{
 		RecipientAddress: experiment.WalletAddress,
@@ -416,7 +412,7 @@ func MintNFT(db *gorm.DB, experiment *models.Experiment, metadataCID string) err
 		return fmt.Errorf("minting process failed: %s", string(body))
 	}
 
-	log.Println("Minting process successful.")
+	slog.Info("Minting process successful", "experiment_id", experiment.ID)
 
 	return nil
 }
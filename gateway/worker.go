@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/labdao/plex/gateway/config"
+	"github.com/labdao/plex/gateway/utils"
+	"github.com/labdao/plex/internal/logging"
+)
+
+// RunWorkers runs the queue workers and the running job monitor without the
+// web app, so they can be scaled independently of it. cfg must have passed
+// Validate.
+func RunWorkers(cfg *config.Config) {
+	logging.Setup()
+	shutdownTracing := setupTracing("plex-worker")
+	configure(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db := SetupDatabase(cfg.Database)
+	stopBackgroundJobs := startBackgroundJobs(ctx, cfg, db)
+	slog.Info("Workers started", "instance_id", utils.InstanceID)
+
+	// Job durations and retries are recorded where the jobs are processed,
+	// so worker processes expose their own metrics
+	metricsServer := serveMetrics(cfg.Server.WorkerMetricsAddr, db)
+
+	shutdownCtx, cancel := waitForSignal(ctx, stop, cfg)
+	defer cancel()
+
+	stopBackgroundJobs(shutdownCtx)
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	closeResources(db, shutdownTracing)
+}
@@ -1,46 +1,61 @@
 package ipwl
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
 	"sort"
 	"strconv"
 
+	"github.com/labdao/plex/internal/logging"
 	"github.com/labdao/plex/internal/web3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("github.com/labdao/plex/internal/ipwl")
+
 var (
 	inputs           string
 	scatteringMethod string
 )
 
-func InitializeIo(modelPath string, scatteringMethod string, inputVectors map[string][]interface{}, db *gorm.DB) ([]IO, error) {
-	model, modelInfo, err := ReadModelConfig(modelPath, db)
+func InitializeIo(ctx context.Context, modelPath string, scatteringMethod string, kwargs map[string]interface{}, options ScatteringOptions, db *gorm.DB) (ioList []IO, err error) {
+	ctx, span := tracer.Start(ctx, "ipwl.InitializeIo", trace.WithAttributes(
+		attribute.String("model.s3_uri", modelPath),
+		attribute.String("scattering_method", scatteringMethod),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("io.count", len(ioList)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	model, modelInfo, err := ReadModelConfig(ctx, modelPath, db)
 	if err != nil {
 		return nil, err
 	}
 
-	err = validateInputKeys(inputVectors, model.Inputs)
+	// Ranges are expanded before scattering, like lists of their values
+	inputVectors, err := expandKwargs(model.Inputs, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	inputKeys, inputsList, err := scatter(scatteringMethod, model.Inputs, inputVectors, options)
 	if err != nil {
 		return nil, err
 	}
+	inputKeys, inputsList = applyDefaults(model.Inputs, inputKeys, inputsList)
 
-	var inputsList [][]interface{}
-	switch scatteringMethod {
-	case "dotProduct":
-		inputsList, err = dotProductScattering(inputVectors)
-		if err != nil {
-			return nil, err
-		}
-	case "crossProduct":
-		inputsList, err = crossProductScattering(inputVectors)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("invalid scattering method: %s", scatteringMethod)
+	// Reject the whole experiment before any job is created
+	if err := validateJobInputs(model.Inputs, inputKeys, inputsList); err != nil {
+		return nil, err
 	}
 
 	var walletAddress string
@@ -48,14 +63,12 @@ func InitializeIo(modelPath string, scatteringMethod string, inputVectors map[st
 	if web3.IsValidEthereumAddress(os.Getenv("RECIPIENT_WALLET")) {
 		walletAddress = os.Getenv("RECIPIENT_WALLET")
 	} else {
-		fmt.Print("Invalid wallet address detected. Using empty string for user ID.\n")
+		logging.FromContext(ctx).Debug("RECIPIENT_WALLET is not a valid wallet address, using an empty wallet address")
 		walletAddress = ""
 	}
 
-	var ioList []IO
-
 	for _, inputs := range inputsList {
-		io, err := createSingleIo(inputs, model, modelInfo, walletAddress, inputVectors)
+		io, err := createSingleIo(inputs, model, modelInfo, walletAddress, inputKeys)
 		if err != nil {
 			return nil, err
 		}
@@ -65,17 +78,6 @@ func InitializeIo(modelPath string, scatteringMethod string, inputVectors map[st
 	return ioList, nil
 }
 
-func validateInputKeys(inputVectors map[string][]interface{}, modelInputs map[string]ModelInput) error {
-	for inputKey := range inputVectors {
-		if _, exists := modelInputs[inputKey]; !exists {
-			log.Printf("The argument %s is not in the model inputs.\n", inputKey)
-			log.Printf("Available keys: %v\n", modelInputs)
-			return fmt.Errorf("the argument %s is not in the model inputs", inputKey)
-		}
-	}
-	return nil
-}
-
 func dotProductScattering(inputVectors map[string][]interface{}) ([][]interface{}, error) {
 	var vectorLength int
 	for _, v := range inputVectors {
@@ -84,7 +86,7 @@ func dotProductScattering(inputVectors map[string][]interface{}) ([][]interface{
 			continue
 		}
 		if len(v) != vectorLength {
-			return nil, fmt.Errorf("all input arguments must have the same length for dotProduct scattering method")
+			return nil, fmt.Errorf("%w: all input arguments must have the same length for dotProduct scattering method", ErrInvalidScattering)
 		}
 	}
 
@@ -107,23 +109,6 @@ func dotProductScattering(inputVectors map[string][]interface{}) ([][]interface{
 }
 
 func crossProductScattering(inputVectors map[string][]interface{}) ([][]interface{}, error) {
-	// Cartesian product function adapted for slices of interfaces
-	cartesian := func(arrs ...[]interface{}) [][]interface{} {
-		result := [][]interface{}{{}}
-		for _, arr := range arrs {
-			var temp [][]interface{}
-			for _, res := range result {
-				for _, item := range arr {
-					product := append([]interface{}{}, res...) // Copy current slice of interfaces
-					product = append(product, item)            // Append the new item
-					temp = append(temp, product)               // Append the new product to the temporary result
-				}
-			}
-			result = temp // Set the result to the temporary result
-		}
-		return result
-	}
-
 	// Extracting the keys and sorting them
 	keys := make([]string, 0, len(inputVectors))
 	for k := range inputVectors {
@@ -143,6 +128,23 @@ func crossProductScattering(inputVectors map[string][]interface{}) ([][]interfac
 	return inputsList, nil
 }
 
+// Cartesian product function adapted for slices of interfaces
+func cartesian(arrs ...[]interface{}) [][]interface{} {
+	result := [][]interface{}{{}}
+	for _, arr := range arrs {
+		var temp [][]interface{}
+		for _, res := range result {
+			for _, item := range arr {
+				product := append([]interface{}{}, res...) // Copy current slice of interfaces
+				product = append(product, item)            // Append the new item
+				temp = append(temp, product)               // Append the new product to the temporary result
+			}
+		}
+		result = temp // Set the result to the temporary result
+	}
+	return result
+}
+
 // func createSingleIo(inputs []interface{}, model Model, modelInfo ModelInfo, walletAddress string, inputVectors map[string][]interface{}) (IO, error) {
 // 	io := IO{
 // 		Model:         modelInfo,
@@ -173,7 +175,9 @@ func crossProductScattering(inputVectors map[string][]interface{}) ([][]interfac
 // 	return io, nil
 // }
 
-func createSingleIo(inputs []interface{}, model Model, modelInfo ModelInfo, walletAddress string, inputVectors map[string][]interface{}) (IO, error) {
+// createSingleIo builds the IO of one job, inputKeys are the sorted keys the
+// inputs belong to.
+func createSingleIo(inputs []interface{}, model Model, modelInfo ModelInfo, walletAddress string, inputKeys []string) (IO, error) {
 	io := IO{
 		Model:         modelInfo,
 		Inputs:        make(map[string]interface{}),
@@ -183,17 +187,9 @@ func createSingleIo(inputs []interface{}, model Model, modelInfo ModelInfo, wall
 		WalletAddress: walletAddress,
 	}
 
-	inputKeys := make([]string, 0, len(inputVectors))
-	for k := range inputVectors {
-		inputKeys = append(inputKeys, k)
-	}
-
-	// Sort the inputKeys slice to ensure a consistent order
-	sort.Strings(inputKeys)
-
 	for i, inputValue := range inputs {
 		inputKey := inputKeys[i]
-		io.Inputs[inputKey] = processInputValue(inputValue, model.Inputs[inputKey].Type)
+		io.Inputs[inputKey] = processInput(inputValue, model.Inputs[inputKey])
 	}
 
 	for outputKey, outputValue := range model.Outputs {
@@ -203,6 +199,21 @@ func createSingleIo(inputs []interface{}, model Model, modelInfo ModelInfo, wall
 	return io, nil
 }
 
+// processInput converts the value of an input to its type, item by item for
+// array inputs.
+func processInput(value interface{}, input ModelInput) interface{} {
+	items, ok := value.([]interface{})
+	if !input.Array || !ok {
+		return processInputValue(value, input.Type)
+	}
+	// An empty list stays a list, the model gets [] rather than nothing
+	processed := make([]interface{}, len(items))
+	for i, item := range items {
+		processed[i] = processInputValue(item, input.Type)
+	}
+	return processed
+}
+
 func processInputValue(value interface{}, expectedType string) interface{} {
 	switch v := value.(type) {
 	case string:
@@ -0,0 +1,219 @@
+package ipwl
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InputError is a problem with the value of one input. Job is the index of
+// the scattered job it concerns, or nil if it concerns all of them.
+type InputError struct {
+	Job     *int        `json:"job,omitempty"`
+	Input   string      `json:"input"`
+	Value   interface{} `json:"value,omitempty"`
+	Message string      `json:"message"`
+}
+
+func (e InputError) Error() string {
+	if e.Job == nil {
+		return fmt.Sprintf("%s: %s", e.Input, e.Message)
+	}
+	return fmt.Sprintf("job %d: %s: %s", *e.Job, e.Input, e.Message)
+}
+
+// InputErrors are all problems found in the inputs of an experiment.
+type InputErrors []InputError
+
+func (e InputErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// applyDefaults fills in the manifest defaults of inputs that were omitted,
+// or left empty in a job, so that the inputs stored with each job are the
+// parameters it ran with. It returns the sorted keys of the merged inputs.
+func applyDefaults(modelInputs map[string]ModelInput, inputKeys []string, inputsList [][]interface{}) ([]string, [][]interface{}) {
+	positions := make(map[string]int, len(inputKeys))
+	for i, key := range inputKeys {
+		positions[key] = i
+	}
+	mergedKeys := append([]string{}, inputKeys...)
+	for name, input := range modelInputs {
+		if _, ok := positions[name]; !ok && !input.isEmpty(input.Default) {
+			mergedKeys = append(mergedKeys, name)
+		}
+	}
+	sort.Strings(mergedKeys)
+
+	merged := make([][]interface{}, len(inputsList))
+	for i, inputs := range inputsList {
+		merged[i] = make([]interface{}, len(mergedKeys))
+		for j, key := range mergedKeys {
+			var value interface{}
+			if position, ok := positions[key]; ok {
+				value = inputs[position]
+			}
+			if input, ok := modelInputs[key]; ok && input.isEmpty(value) && !input.isEmpty(input.Default) {
+				value = input.Default
+			}
+			merged[i][j] = value
+		}
+	}
+	return mergedKeys, merged
+}
+
+// validateJobInputs checks the inputs of every scattered job against the
+// manifest. inputKeys are the sorted keys the values of each job belong to.
+func validateJobInputs(modelInputs map[string]ModelInput, inputKeys []string, inputsList [][]interface{}) error {
+	var errs InputErrors
+
+	given := make(map[string]bool, len(inputKeys))
+	for _, key := range inputKeys {
+		given[key] = true
+		if _, ok := modelInputs[key]; !ok {
+			errs = append(errs, InputError{Input: key, Message: "is not an input of the model"})
+		}
+	}
+	names := make([]string, 0, len(modelInputs))
+	for name := range modelInputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if modelInputs[name].Required && !given[name] {
+			errs = append(errs, InputError{Input: name, Message: "is required"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for i, inputs := range inputsList {
+		for j, value := range inputs {
+			key := inputKeys[j]
+			if message := modelInputs[key].check(value); message != "" {
+				job := i
+				errs = append(errs, InputError{Job: &job, Input: key, Value: value, Message: message})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check returns what is wrong with value, or "" if it is fine. Every job
+// gives array inputs a list of values, and other inputs a single one.
+func (input ModelInput) check(value interface{}) string {
+	if input.isEmpty(value) {
+		if input.Required {
+			return "is required"
+		}
+		return ""
+	}
+
+	items, isList := value.([]interface{})
+	if !input.Array {
+		if isList {
+			return fmt.Sprintf("takes a single value, got a list %v", value)
+		}
+		return input.checkItem(value)
+	}
+	if !isList {
+		return fmt.Sprintf("is an array input and takes a list of values, got %v", value)
+	}
+	for i, item := range items {
+		if _, nested := item.([]interface{}); nested {
+			return fmt.Sprintf("item %d: lists cannot be nested, got %v", i, item)
+		}
+		if isEmptyDefault(item) {
+			return fmt.Sprintf("item %d: must not be empty", i)
+		}
+		if message := input.checkItem(item); message != "" {
+			return fmt.Sprintf("item %d: %s", i, message)
+		}
+	}
+	return ""
+}
+
+// isEmpty reports whether value counts as not given, which for array inputs
+// includes an empty list.
+func (input ModelInput) isEmpty(value interface{}) bool {
+	if items, ok := value.([]interface{}); ok && input.Array {
+		return len(items) == 0
+	}
+	return isEmptyDefault(value)
+}
+
+// checkItem checks a single value against the type of the input.
+func (input ModelInput) checkItem(value interface{}) string {
+	switch input.Type {
+	case "number":
+		number, ok := numberValue(value)
+		if !ok {
+			return fmt.Sprintf("must be a number, got %v", value)
+		}
+		// the bounds were validated with the manifest
+		if lower, _ := parseBound(input.Min); lower != nil && number < *lower {
+			return fmt.Sprintf("must be at least %s, got %v", input.Min, value)
+		}
+		if upper, _ := parseBound(input.Max); upper != nil && number > *upper {
+			return fmt.Sprintf("must be at most %s, got %v", input.Max, value)
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Sprintf("must be true or false, got %q", v)
+			}
+		default:
+			return fmt.Sprintf("must be true or false, got %v", value)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Sprintf("must be a string, got %v", value)
+		}
+	case "file", "File":
+		location, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("must be a file, got %v", value)
+		}
+		if !matchesGlobs(fileName(location), input.Glob) {
+			return fmt.Sprintf("file %s does not match %s", fileName(location), strings.Join(input.Glob, ", "))
+		}
+	}
+	return ""
+}
+
+// fileName returns the name of a file given as a path or URI.
+func fileName(location string) string {
+	if parsed, err := url.Parse(location); err == nil && parsed.Scheme != "" {
+		location = parsed.Path
+	}
+	return path.Base(location)
+}
+
+// matchesGlobs reports whether name matches any of the patterns. Manifests
+// use [""] or no patterns for files of any name.
+func matchesGlobs(name string, globs []string) bool {
+	restricted := false
+	for _, glob := range globs {
+		if glob == "" {
+			continue
+		}
+		restricted = true
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return !restricted
+}
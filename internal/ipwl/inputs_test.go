@@ -0,0 +1,190 @@
+package ipwl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testModelInputs = map[string]ModelInput{
+	"pdb":           {Type: "file", Glob: []string{"*.pdb"}, Required: true},
+	"binder_length": {Type: "number", Min: "1", Max: "1000"},
+	"speedup":       {Type: "boolean"},
+	"target_chain":  {Type: "string", Glob: []string{""}},
+}
+
+func inputErrorsOf(t *testing.T, err error) InputErrors {
+	t.Helper()
+	var inputErrors InputErrors
+	if !errors.As(err, &inputErrors) {
+		t.Fatalf("expected InputErrors, got %v", err)
+	}
+	return inputErrors
+}
+
+func TestValidateJobInputsAcceptsValidInputs(t *testing.T) {
+	keys := []string{"binder_length", "pdb", "speedup", "target_chain"}
+	inputsList := [][]interface{}{
+		{float64(50), "s3://bucket/abc/target.pdb", true, "A"},
+		{"1000", "123/target.pdb", "false", ""},
+		{nil, "target.pdb", nil, nil},
+	}
+	if err := validateJobInputs(testModelInputs, keys, inputsList); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateJobInputsReportsEveryJob(t *testing.T) {
+	keys := []string{"binder_length", "pdb", "speedup", "target_chain"}
+	inputsList := [][]interface{}{
+		{float64(0), "s3://bucket/abc/target.sdf", "yes", "A"},
+		{"many", "", true, float64(1)},
+	}
+	inputErrors := inputErrorsOf(t, validateJobInputs(testModelInputs, keys, inputsList))
+
+	type reported struct {
+		job   int
+		input string
+	}
+	var got []reported
+	for _, e := range inputErrors {
+		if e.Job == nil {
+			t.Fatalf("error without a job: %v", e)
+		}
+		got = append(got, reported{*e.Job, e.Input})
+	}
+	expected := []reported{
+		{0, "binder_length"},
+		{0, "pdb"},
+		{0, "speedup"},
+		{1, "binder_length"},
+		{1, "pdb"},
+		{1, "target_chain"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	if inputErrors[1].Message != "file target.sdf does not match *.pdb" {
+		t.Errorf("unexpected message %q", inputErrors[1].Message)
+	}
+}
+
+func TestValidateJobInputsReportsMissingAndUnknownInputs(t *testing.T) {
+	keys := []string{"binder_length", "hotspots"}
+	inputErrors := inputErrorsOf(t, validateJobInputs(testModelInputs, keys, [][]interface{}{{float64(5), "A1"}}))
+
+	expected := InputErrors{
+		{Input: "hotspots", Message: "is not an input of the model"},
+		{Input: "pdb", Message: "is required"},
+	}
+	if !reflect.DeepEqual(inputErrors, expected) {
+		t.Errorf("got %v, want %v", inputErrors, expected)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	modelInputs := map[string]ModelInput{
+		"pdb":           {Type: "file", Default: ""},
+		"binder_length": {Type: "number", Default: "85"},
+		"speedup":       {Type: "boolean", Default: false},
+		"target_chain":  {Type: "string", Default: "A"},
+	}
+	keys := []string{"pdb", "target_chain"}
+	inputsList := [][]interface{}{
+		{"target.pdb", "B"},
+		{"target.pdb", ""},
+	}
+
+	mergedKeys, merged := applyDefaults(modelInputs, keys, inputsList)
+
+	if expected := []string{"binder_length", "pdb", "speedup", "target_chain"}; !reflect.DeepEqual(mergedKeys, expected) {
+		t.Fatalf("keys = %v, want %v", mergedKeys, expected)
+	}
+	expected := [][]interface{}{
+		{"85", "target.pdb", false, "B"},
+		{"85", "target.pdb", false, "A"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("inputs = %v, want %v", merged, expected)
+	}
+	if err := validateJobInputs(modelInputs, mergedKeys, merged); err != nil {
+		t.Errorf("defaults do not validate: %v", err)
+	}
+}
+
+func TestValidateJobInputsArrays(t *testing.T) {
+	modelInputs := map[string]ModelInput{
+		"pdbs":     {Type: "file", Array: true, Glob: []string{"*.pdb"}, Required: true},
+		"hotspots": {Type: "number", Array: true, Min: "1"},
+		"chain":    {Type: "string"},
+	}
+	keys := []string{"chain", "hotspots", "pdbs"}
+
+	valid := [][]interface{}{
+		{"A", []interface{}{float64(1), "23"}, []interface{}{"a.pdb", "b.pdb"}},
+		{nil, nil, []interface{}{"a.pdb"}},
+	}
+	if err := validateJobInputs(modelInputs, keys, valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := [][]interface{}{
+		{[]interface{}{"A"}, []interface{}{float64(0)}, "a.pdb"},
+		{"A", []interface{}{[]interface{}{float64(2)}}, []interface{}{}},
+		{"A", nil, []interface{}{"a.pdb", "b.sdf"}},
+	}
+	inputErrors := inputErrorsOf(t, validateJobInputs(modelInputs, keys, invalid))
+	var messages []string
+	for _, e := range inputErrors {
+		messages = append(messages, e.Input+": "+e.Message)
+	}
+	expected := []string{
+		"chain: takes a single value, got a list [A]",
+		"hotspots: item 0: must be at least 1, got 0",
+		"pdbs: is an array input and takes a list of values, got a.pdb",
+		"hotspots: item 0: lists cannot be nested, got [2]",
+		"pdbs: is required",
+		"pdbs: item 1: file b.sdf does not match *.pdb",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("got %q, want %q", messages, expected)
+	}
+}
+
+func TestCreateSingleIoKeepsArrays(t *testing.T) {
+	model := Model{Inputs: map[string]ModelInput{
+		"hotspots": {Type: "number", Array: true},
+		"speedup":  {Type: "boolean"},
+	}}
+	inputVectors := map[string][]interface{}{
+		"hotspots": {[]interface{}{"1", float64(2.5)}, []interface{}{}},
+		"speedup":  {"true", false},
+	}
+	inputsList, err := dotProductScattering(inputVectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputsList) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(inputsList))
+	}
+
+	io, err := createSingleIo(inputsList[0], model, ModelInfo{}, "", []string{"hotspots", "speedup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"hotspots": []interface{}{1, 2.5},
+		"speedup":  true,
+	}
+	if !reflect.DeepEqual(map[string]interface{}(io.Inputs), expected) {
+		t.Errorf("inputs = %v, want %v", io.Inputs, expected)
+	}
+	// an empty list is not dropped
+	io, err = createSingleIo(inputsList[1], model, ModelInfo{}, "", []string{"hotspots", "speedup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hotspots, ok := io.Inputs["hotspots"].([]interface{}); !ok || len(hotspots) != 0 {
+		t.Errorf("hotspots = %#v, want an empty list", io.Inputs["hotspots"])
+	}
+}
@@ -1,6 +1,7 @@
 package ipwl
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -37,10 +38,30 @@ type ModelType string
 const (
 	ModelTypeBacalhau ModelType = "bacalhau"
 	ModelTypeRay      ModelType = "ray"
+	ModelTypeExec     ModelType = "exec"
 )
 
+// ModelResources are what a single job of the model needs to run.
+type ModelResources struct {
+	CPU      float64 `json:"cpu"`
+	MemoryGb int     `json:"memoryGb"`
+	GPU      int     `json:"gpu"`
+}
+
+// RetryPolicy controls how often the gateway retries submitting a job whose
+// submission failed. Unset fields fall back to the gateway defaults, so zero
+// values like "maxRetries": 0 are kept apart from missing ones.
+type RetryPolicy struct {
+	// StatusCodes are the response codes of a failed submission worth retrying
+	StatusCodes           []int    `json:"statusCodes"`
+	MaxRetries            *int     `json:"maxRetries,omitempty"`
+	InitialBackoffSeconds *float64 `json:"initialBackoffSeconds,omitempty"`
+	BackoffMultiplier     *float64 `json:"backoffMultiplier,omitempty"`
+}
+
 type Model struct {
 	Name                 string                 `json:"name"`
+	Version              string                 `json:"version,omitempty"`
 	Description          string                 `json:"description"`
 	Guide                string                 `json:"guide"`
 	Author               string                 `json:"author"`
@@ -59,9 +80,11 @@ type Model struct {
 	XAxis                string                 `json:"xAxis"`
 	YAxis                string                 `json:"yAxis"`
 	JobType              models.JobType         `json:"jobType"`
+	RetryPolicy          *RetryPolicy           `json:"retryPolicy,omitempty"`
+	Resources            ModelResources         `json:"resources"`
 }
 
-func ReadModelConfig(modelPath string, db *gorm.DB) (Model, ModelInfo, error) {
+func ReadModelConfig(ctx context.Context, modelPath string, db *gorm.DB) (Model, ModelInfo, error) {
 	var ipwlmodel Model
 	var dbModel models.Model
 	var modelInfo ModelInfo
@@ -83,19 +106,18 @@ func ReadModelConfig(modelPath string, db *gorm.DB) (Model, ModelInfo, error) {
 		return ipwlmodel, modelInfo, fmt.Errorf("failed to get bucket and key from URI: %w", err)
 	}
 	fileName := filepath.Base(key)
-	err = s3client.DownloadFile(bucket, key, fileName)
+	err = s3client.DownloadFileWithContext(ctx, bucket, key, fileName)
 	if err != nil {
 		return ipwlmodel, modelInfo, fmt.Errorf("failed to download file: %w", err)
 	}
 
 	file, err := os.Open(fileName)
+	defer os.Remove(fileName)
 	if err != nil {
-		fmt.Printf("Failed to open file: %v\n", err)
+		return ipwlmodel, modelInfo, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
-	defer os.Remove(fileName)
 
-	fmt.Println("File opened successfully")
 	bytes, err := io.ReadAll(file)
 	if err != nil {
 		return ipwlmodel, modelInfo, fmt.Errorf("failed to read file: %w", err)
@@ -0,0 +1,163 @@
+package ipwl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxRangeValues bounds the values a single range expands to.
+const maxRangeValues = 10000
+
+// NumberRange is a sweep over a number input given in kwargs instead of a
+// list of values. Step gives start, start+step, ... up to but excluding stop.
+// Num gives num evenly spaced values from start to stop inclusive, on a
+// linear scale or, with scale "log", as base^x for x from start to stop.
+type NumberRange struct {
+	Start *float64 `json:"start"`
+	Stop  *float64 `json:"stop"`
+	Step  *float64 `json:"step,omitempty"`
+	Num   *int     `json:"num,omitempty"`
+	Scale string   `json:"scale,omitempty"`
+	Base  *float64 `json:"base,omitempty"`
+}
+
+// expandKwargs turns the kwargs of an experiment into the input vectors to
+// scatter, expanding the ranges of number inputs.
+func expandKwargs(modelInputs map[string]ModelInput, kwargs map[string]interface{}) (map[string][]interface{}, error) {
+	var errs InputErrors
+	inputVectors := make(map[string][]interface{}, len(kwargs))
+	for _, key := range sortedKeys(kwargs) {
+		switch v := kwargs[key].(type) {
+		case []interface{}:
+			inputVectors[key] = v
+		case map[string]interface{}:
+			values, message := expandRange(modelInputs, key, v)
+			if message != "" {
+				errs = append(errs, InputError{Input: key, Value: v, Message: message})
+				continue
+			}
+			inputVectors[key] = values
+		default:
+			errs = append(errs, InputError{Input: key, Value: v, Message: "must be a list of values or a range"})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return inputVectors, nil
+}
+
+// expandRange returns the values of a range given for the input key, or what
+// is wrong with it.
+func expandRange(modelInputs map[string]ModelInput, key string, spec map[string]interface{}) ([]interface{}, string) {
+	input, ok := modelInputs[key]
+	if !ok {
+		return nil, "is not an input of the model"
+	}
+	if input.Type != "number" || input.Array {
+		return nil, "ranges are only supported for number inputs"
+	}
+
+	var r NumberRange
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid range: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&r); err != nil {
+		return nil, fmt.Sprintf("invalid range: %v", err)
+	}
+	numbers, message := r.values()
+	if message != "" {
+		return nil, message
+	}
+
+	values := make([]interface{}, len(numbers))
+	for i, number := range numbers {
+		if message := input.checkItem(number); message != "" {
+			return nil, fmt.Sprintf("range value %d %s", i, message)
+		}
+		values[i] = number
+	}
+	return values, ""
+}
+
+// values expands the range, or returns what is wrong with it.
+func (r NumberRange) values() ([]float64, string) {
+	if r.Start == nil || r.Stop == nil {
+		return nil, "a range needs a start and a stop"
+	}
+	start, stop := *r.Start, *r.Stop
+	if (r.Step == nil) == (r.Num == nil) {
+		return nil, "a range needs either a step or a num"
+	}
+
+	var values []float64
+	if r.Step != nil {
+		step := *r.Step
+		if r.Scale != "" || r.Base != nil {
+			return nil, "scale and base only apply to ranges with a num"
+		}
+		if step == 0 || (stop-start)/step < 0 {
+			return nil, fmt.Sprintf("step %v does not lead from %v to %v", step, start, stop)
+		}
+		// the small tolerance keeps stop out despite rounding errors
+		count := math.Ceil((stop-start)/step - 1e-9)
+		if count > maxRangeValues {
+			return nil, fmt.Sprintf("expands to more than %d values", maxRangeValues)
+		}
+		for i := 0; i < int(count); i++ {
+			values = append(values, roundRangeValue(start+float64(i)*step))
+		}
+		if len(values) == 0 {
+			return nil, fmt.Sprintf("has no values from %v to %v", start, stop)
+		}
+		return values, ""
+	}
+
+	num := *r.Num
+	if num < 1 {
+		return nil, fmt.Sprintf("num must be at least 1, got %d", num)
+	}
+	if num > maxRangeValues {
+		return nil, fmt.Sprintf("expands to more than %d values", maxRangeValues)
+	}
+	base := 10.0
+	switch r.Scale {
+	case "", "linear":
+		if r.Base != nil {
+			return nil, "base only applies to ranges with scale log"
+		}
+	case "log":
+		if r.Base != nil {
+			base = *r.Base
+		}
+		if base <= 0 || base == 1 {
+			return nil, fmt.Sprintf("base must be positive and not 1, got %v", base)
+		}
+	default:
+		return nil, fmt.Sprintf("scale must be linear or log, got %q", r.Scale)
+	}
+	for i := 0; i < num; i++ {
+		x := start
+		if num > 1 {
+			x = start + float64(i)*(stop-start)/float64(num-1)
+		}
+		if r.Scale == "log" {
+			x = math.Pow(base, x)
+		}
+		values = append(values, roundRangeValue(x))
+	}
+	return values, ""
+}
+
+// roundRangeValue drops the rounding errors of the arithmetic, so that a
+// step of 0.1 gives 0.3 rather than 0.30000000000000004.
+func roundRangeValue(value float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 12, 64), 64)
+	return rounded
+}
@@ -0,0 +1,99 @@
+package ipwl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandKwargsExpandsRanges(t *testing.T) {
+	modelInputs := map[string]ModelInput{
+		"binder_length": {Type: "number", Min: "1", Max: "1000"},
+		"temperature":   {Type: "number"},
+		"learning_rate": {Type: "number"},
+		"pdb":           {Type: "file"},
+	}
+	kwargs := map[string]interface{}{
+		"binder_length": map[string]interface{}{"start": float64(50), "stop": float64(100), "step": float64(10)},
+		"temperature":   map[string]interface{}{"start": float64(0), "stop": float64(1), "num": float64(5)},
+		"learning_rate": map[string]interface{}{"start": float64(-3), "stop": float64(0), "num": float64(4), "scale": "log"},
+		"pdb":           []interface{}{"a.pdb"},
+	}
+	inputVectors, err := expandKwargs(modelInputs, kwargs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]interface{}{
+		"binder_length": {float64(50), float64(60), float64(70), float64(80), float64(90)},
+		"temperature":   {0.0, 0.25, 0.5, 0.75, 1.0},
+		"learning_rate": {0.001, 0.01, 0.1, 1.0},
+		"pdb":           {"a.pdb"},
+	}
+	if !reflect.DeepEqual(inputVectors, expected) {
+		t.Errorf("got %v, want %v", inputVectors, expected)
+	}
+}
+
+func TestNumberRangeRoundsSteps(t *testing.T) {
+	start, stop, step := 0.0, 0.5, 0.1
+	values, message := NumberRange{Start: &start, Stop: &stop, Step: &step}.values()
+	if message != "" {
+		t.Fatal(message)
+	}
+	if expected := []float64{0, 0.1, 0.2, 0.3, 0.4}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("got %v, want %v", values, expected)
+	}
+
+	start, stop, step = 10, 0, -5
+	values, _ = NumberRange{Start: &start, Stop: &stop, Step: &step}.values()
+	if expected := []float64{10, 5}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("got %v, want %v", values, expected)
+	}
+}
+
+func TestExpandKwargsRejectsInvalidRanges(t *testing.T) {
+	modelInputs := map[string]ModelInput{
+		"binder_length": {Type: "number", Min: "1", Max: "1000"},
+		"pdb":           {Type: "file"},
+	}
+	cases := map[string]struct {
+		kwargs  map[string]interface{}
+		message string
+	}{
+		"beyond max": {
+			map[string]interface{}{"binder_length": map[string]interface{}{"start": float64(900), "stop": float64(1200), "step": float64(100)}},
+			"range value 2 must be at most 1000, got 1100",
+		},
+		"not a number input": {
+			map[string]interface{}{"pdb": map[string]interface{}{"start": float64(1), "stop": float64(2), "step": float64(1)}},
+			"ranges are only supported for number inputs",
+		},
+		"wrong direction": {
+			map[string]interface{}{"binder_length": map[string]interface{}{"start": float64(10), "stop": float64(1), "step": float64(1)}},
+			"step 1 does not lead from 10 to 1",
+		},
+		"step and num": {
+			map[string]interface{}{"binder_length": map[string]interface{}{"start": float64(1), "stop": float64(10), "step": float64(1), "num": float64(3)}},
+			"a range needs either a step or a num",
+		},
+		"unknown field": {
+			map[string]interface{}{"binder_length": map[string]interface{}{"start": float64(1), "end": float64(10), "num": float64(3)}},
+			"invalid range",
+		},
+		"too many values": {
+			map[string]interface{}{"binder_length": map[string]interface{}{"start": float64(1), "stop": float64(1000), "step": 0.01}},
+			"expands to more than 10000 values",
+		},
+		"scalar": {
+			map[string]interface{}{"binder_length": float64(50)},
+			"must be a list of values or a range",
+		},
+	}
+	for name, c := range cases {
+		_, err := expandKwargs(modelInputs, c.kwargs)
+		inputErrors := inputErrorsOf(t, err)
+		if len(inputErrors) != 1 || !strings.HasPrefix(inputErrors[0].Message, c.message) {
+			t.Errorf("%s: got %v, want %q", name, inputErrors, c.message)
+		}
+	}
+}
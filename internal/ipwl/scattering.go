@@ -0,0 +1,322 @@
+package ipwl
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidScattering is returned when the inputs cannot be scattered with
+// the requested method and options.
+var ErrInvalidScattering = errors.New("invalid scattering")
+
+// ScatteringOptions configure the scattering methods beyond dotProduct and
+// crossProduct. Methods ignore the options they do not use.
+type ScatteringOptions struct {
+	// Samples is the number of jobs of randomSample and latinHypercube, at
+	// most maxRangeValues.
+	Samples int `json:"samples,omitempty"`
+	// Seed makes randomSample and latinHypercube reproducible.
+	Seed int64 `json:"seed,omitempty"`
+	// Ranges are the number inputs sampled by latinHypercube.
+	Ranges map[string]ScatteringRange `json:"ranges,omitempty"`
+	// Rows is the CSV of rowList, a header of input names and a row per job.
+	Rows string `json:"rows,omitempty"`
+	// Groups are the inputs zipCross zips together before crossing.
+	Groups [][]string `json:"groups,omitempty"`
+}
+
+// ScatteringRange is the interval a latinHypercube input is sampled from.
+// Integer ranges are sampled as whole numbers.
+type ScatteringRange struct {
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Integer bool    `json:"integer,omitempty"`
+}
+
+// scatter turns the input vectors into the inputs of every job. It returns
+// the sorted keys the values of each job belong to.
+func scatter(method string, modelInputs map[string]ModelInput, inputVectors map[string][]interface{}, options ScatteringOptions) ([]string, [][]interface{}, error) {
+	keys := sortedKeys(inputVectors)
+	var inputsList [][]interface{}
+	var err error
+	switch method {
+	case "dotProduct":
+		inputsList, err = dotProductScattering(inputVectors)
+	case "crossProduct":
+		inputsList, err = crossProductScattering(inputVectors)
+	case "randomSample":
+		inputsList, err = randomSampleScattering(inputVectors, options.Samples, options.Seed)
+	case "latinHypercube":
+		return latinHypercubeScattering(inputVectors, options.Ranges, options.Samples, options.Seed)
+	case "rowList":
+		return rowListScattering(modelInputs, inputVectors, options.Rows)
+	case "zipCross":
+		inputsList, err = zipCrossScattering(inputVectors, options.Groups)
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown scattering method %s", ErrInvalidScattering, method)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, inputsList, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// randomSampleScattering draws samples distinct jobs from the cross product
+// of the input vectors. The jobs keep their cross product order.
+func randomSampleScattering(inputVectors map[string][]interface{}, samples int, seed int64) ([][]interface{}, error) {
+	if err := checkSamples("randomSample", samples); err != nil {
+		return nil, err
+	}
+	keys := sortedKeys(inputVectors)
+	total := big.NewInt(1)
+	for _, key := range keys {
+		total.Mul(total, big.NewInt(int64(len(inputVectors[key]))))
+	}
+	if total.Sign() == 0 {
+		return nil, nil
+	}
+	if total.Cmp(big.NewInt(int64(samples))) <= 0 {
+		return crossProductScattering(inputVectors)
+	}
+	if !total.IsInt64() {
+		return nil, fmt.Errorf("%w: the cross product of %s jobs is too large to sample", ErrInvalidScattering, total)
+	}
+	indices := sampleIndices(rand.New(rand.NewSource(seed)), total.Int64(), samples)
+
+	inputsList := make([][]interface{}, len(indices))
+	for i, index := range indices {
+		// the last key varies fastest, as in crossProductScattering
+		inputs := make([]interface{}, len(keys))
+		for j := len(keys) - 1; j >= 0; j-- {
+			vector := inputVectors[keys[j]]
+			inputs[j] = vector[index%int64(len(vector))]
+			index /= int64(len(vector))
+		}
+		inputsList[i] = inputs
+	}
+	return inputsList, nil
+}
+
+// checkSamples bounds the number of samples, the jobs and the memory to
+// sample them are proportional to it.
+func checkSamples(method string, samples int) error {
+	if samples < 1 {
+		return fmt.Errorf("%w: %s needs at least 1 sample, got %d", ErrInvalidScattering, method, samples)
+	}
+	if samples > maxRangeValues {
+		return fmt.Errorf("%w: %s takes at most %d samples, got %d", ErrInvalidScattering, method, maxRangeValues, samples)
+	}
+	return nil
+}
+
+// sampleIndices picks samples distinct indices below total with Floyd's
+// algorithm, so that large cross products are never built, and sorts them.
+func sampleIndices(rng *rand.Rand, total int64, samples int) []int64 {
+	picked := make(map[int64]bool, samples)
+	indices := make([]int64, 0, samples)
+	for j := total - int64(samples); j < total; j++ {
+		index := rng.Int63n(j + 1)
+		if picked[index] {
+			index = j
+		}
+		picked[index] = true
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(a, b int) bool { return indices[a] < indices[b] })
+	return indices
+}
+
+// latinHypercubeScattering samples the ranges so that every one of the
+// samples intervals of each range is used exactly once. The input vectors
+// of the other inputs are crossed with the samples.
+func latinHypercubeScattering(inputVectors map[string][]interface{}, ranges map[string]ScatteringRange, samples int, seed int64) ([]string, [][]interface{}, error) {
+	if err := checkSamples("latinHypercube", samples); err != nil {
+		return nil, nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil, fmt.Errorf("%w: latinHypercube needs at least one range", ErrInvalidScattering)
+	}
+	rangeKeys := sortedKeys(ranges)
+	for _, key := range rangeKeys {
+		if _, ok := inputVectors[key]; ok {
+			return nil, nil, fmt.Errorf("%w: %s is given both as a range and in kwargs", ErrInvalidScattering, key)
+		}
+		if r := ranges[key]; r.Min > r.Max {
+			return nil, nil, fmt.Errorf("%w: range of %s has min %v greater than max %v", ErrInvalidScattering, key, r.Min, r.Max)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	rows := make([][]interface{}, samples)
+	for i := range rows {
+		rows[i] = make([]interface{}, len(rangeKeys))
+	}
+	for j, key := range rangeKeys {
+		r := ranges[key]
+		for i, stratum := range rng.Perm(samples) {
+			value := r.Min + (float64(stratum)+rng.Float64())/float64(samples)*(r.Max-r.Min)
+			if r.Integer {
+				value = math.Min(math.Max(math.Round(value), math.Ceil(r.Min)), math.Floor(r.Max))
+			}
+			rows[i][j] = value
+		}
+	}
+	return crossWithRows(rangeKeys, rows, inputVectors)
+}
+
+// rowListScattering runs a job per row of a CSV whose header names the
+// inputs. Empty cells leave the input to its default. The input vectors of
+// other inputs are crossed with the rows. A cell holds a single value, so
+// array inputs can't be columns.
+func rowListScattering(modelInputs map[string]ModelInput, inputVectors map[string][]interface{}, rowsCSV string) ([]string, [][]interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(rowsCSV)).ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: rows are not valid CSV: %v", ErrInvalidScattering, err)
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("%w: rowList needs a header and at least one row", ErrInvalidScattering)
+	}
+	header := records[0]
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, nil, fmt.Errorf("%w: column %d of the header has no name", ErrInvalidScattering, i)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, nil, fmt.Errorf("%w: column %s appears twice", ErrInvalidScattering, name)
+		}
+		if _, ok := inputVectors[name]; ok {
+			return nil, nil, fmt.Errorf("%w: %s is given both as a column and in kwargs", ErrInvalidScattering, name)
+		}
+		if modelInputs[name].Array {
+			return nil, nil, fmt.Errorf("%w: %s is an array input and can't be a column", ErrInvalidScattering, name)
+		}
+		columns[name] = i
+	}
+
+	rowKeys := sortedKeys(columns)
+	rows := make([][]interface{}, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make([]interface{}, len(rowKeys))
+		for j, key := range rowKeys {
+			row[j] = strings.TrimSpace(record[columns[key]])
+		}
+		rows = append(rows, row)
+	}
+	return crossWithRows(rowKeys, rows, inputVectors)
+}
+
+// crossWithRows runs every row with every combination of the input vectors.
+// Rows vary slowest, so jobs of the same row are next to each other.
+func crossWithRows(rowKeys []string, rows [][]interface{}, inputVectors map[string][]interface{}) ([]string, [][]interface{}, error) {
+	combinations, err := crossProductScattering(inputVectors)
+	if err != nil {
+		return nil, nil, err
+	}
+	vectorKeys := sortedKeys(inputVectors)
+	keys := append(append([]string{}, rowKeys...), vectorKeys...)
+	sort.Strings(keys)
+	positions := make(map[string]int, len(keys))
+	for i, key := range keys {
+		positions[key] = i
+	}
+
+	var inputsList [][]interface{}
+	for _, row := range rows {
+		for _, combination := range combinations {
+			inputs := make([]interface{}, len(keys))
+			for j, key := range rowKeys {
+				inputs[positions[key]] = row[j]
+			}
+			for j, key := range vectorKeys {
+				inputs[positions[key]] = combination[j]
+			}
+			inputsList = append(inputsList, inputs)
+		}
+	}
+	return keys, inputsList, nil
+}
+
+// zipCrossScattering zips the inputs of each group like dotProduct and
+// crosses the groups like crossProduct. Inputs in no group form a group of
+// their own. Groups are crossed in the order of their first sorted input.
+func zipCrossScattering(inputVectors map[string][]interface{}, groups [][]string) ([][]interface{}, error) {
+	grouped := make(map[string]bool, len(inputVectors))
+	var sortedGroups [][]string
+	for i, group := range groups {
+		if len(group) == 0 {
+			return nil, fmt.Errorf("%w: group %d is empty", ErrInvalidScattering, i)
+		}
+		group = append([]string{}, group...)
+		sort.Strings(group)
+		for _, key := range group {
+			if _, ok := inputVectors[key]; !ok {
+				return nil, fmt.Errorf("%w: group %d names %s, which is not in kwargs", ErrInvalidScattering, i, key)
+			}
+			if grouped[key] {
+				return nil, fmt.Errorf("%w: %s is in more than one group", ErrInvalidScattering, key)
+			}
+			grouped[key] = true
+		}
+		sortedGroups = append(sortedGroups, group)
+	}
+	for _, key := range sortedKeys(inputVectors) {
+		if !grouped[key] {
+			sortedGroups = append(sortedGroups, []string{key})
+		}
+	}
+	sort.Slice(sortedGroups, func(a, b int) bool { return sortedGroups[a][0] < sortedGroups[b][0] })
+
+	// each group becomes a single vector of its zipped inputs
+	vectors := make([][]interface{}, len(sortedGroups))
+	for i, group := range sortedGroups {
+		length := len(inputVectors[group[0]])
+		for _, key := range group[1:] {
+			if len(inputVectors[key]) != length {
+				return nil, fmt.Errorf("%w: inputs of the group of %s must have the same length for zipCross scattering", ErrInvalidScattering, strings.Join(group, ", "))
+			}
+		}
+		vectors[i] = make([]interface{}, length)
+		for j := range vectors[i] {
+			values := make([]interface{}, len(group))
+			for k, key := range group {
+				values[k] = inputVectors[key][j]
+			}
+			vectors[i][j] = values
+		}
+	}
+	combinations := cartesian(vectors...)
+
+	keys := sortedKeys(inputVectors)
+	positions := make(map[string]int, len(keys))
+	for i, key := range keys {
+		positions[key] = i
+	}
+	inputsList := make([][]interface{}, len(combinations))
+	for i, combination := range combinations {
+		inputs := make([]interface{}, len(keys))
+		for g, values := range combination {
+			for k, key := range sortedGroups[g] {
+				inputs[positions[key]] = values.([]interface{})[k]
+			}
+		}
+		inputsList[i] = inputs
+	}
+	return inputsList, nil
+}
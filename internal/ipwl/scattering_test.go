@@ -0,0 +1,165 @@
+package ipwl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRandomSampleScatteringIsDeterministic(t *testing.T) {
+	inputVectors := map[string][]interface{}{
+		"binder_length": {float64(50), float64(60), float64(70), float64(80)},
+		"pdb":           {"a.pdb", "b.pdb", "c.pdb"},
+	}
+	options := ScatteringOptions{Samples: 5, Seed: 42}
+	keys, first, err := scatter("randomSample", nil, inputVectors, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"binder_length", "pdb"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys = %v, want %v", keys, expected)
+	}
+	if len(first) != 5 {
+		t.Fatalf("got %d jobs, want 5", len(first))
+	}
+	_, second, _ := scatter("randomSample", nil, inputVectors, options)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed gave %v and %v", first, second)
+	}
+
+	// samples are distinct and keep the cross product order
+	all, _ := crossProductScattering(inputVectors)
+	position := 0
+	for _, inputs := range first {
+		for position < len(all) && !reflect.DeepEqual(all[position], inputs) {
+			position++
+		}
+		if position == len(all) {
+			t.Fatalf("%v is out of order or not in the cross product", inputs)
+		}
+		position++
+	}
+
+	_, everything, _ := scatter("randomSample", nil, inputVectors, ScatteringOptions{Samples: 20})
+	if !reflect.DeepEqual(everything, all) {
+		t.Errorf("more samples than jobs should give the cross product, got %v", everything)
+	}
+}
+
+func TestLatinHypercubeScatteringCoversEveryStratum(t *testing.T) {
+	inputVectors := map[string][]interface{}{"pdb": {"a.pdb", "b.pdb"}}
+	options := ScatteringOptions{
+		Samples: 4,
+		Seed:    7,
+		Ranges: map[string]ScatteringRange{
+			"binder_length": {Min: 10, Max: 50, Integer: true},
+			"temperature":   {Min: 0, Max: 1},
+		},
+	}
+	keys, inputsList, err := scatter("latinHypercube", nil, inputVectors, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"binder_length", "pdb", "temperature"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("keys = %v, want %v", keys, expected)
+	}
+	if len(inputsList) != 8 {
+		t.Fatalf("got %d jobs, want 8", len(inputsList))
+	}
+
+	strata := map[int]bool{}
+	for i := 0; i < len(inputsList); i += 2 {
+		// both pdbs run with the same sample
+		if inputsList[i][1] != "a.pdb" || inputsList[i+1][1] != "b.pdb" || inputsList[i][2] != inputsList[i+1][2] {
+			t.Fatalf("unexpected jobs %v and %v", inputsList[i], inputsList[i+1])
+		}
+		length := inputsList[i][0].(float64)
+		if length != float64(int(length)) || length < 10 || length > 50 {
+			t.Errorf("binder_length %v is not a whole number in the range", length)
+		}
+		strata[int(inputsList[i][2].(float64)*4)] = true
+	}
+	if len(strata) != 4 {
+		t.Errorf("temperature samples cover strata %v, want all 4", strata)
+	}
+
+	_, again, _ := scatter("latinHypercube", nil, inputVectors, options)
+	if !reflect.DeepEqual(inputsList, again) {
+		t.Errorf("the same seed gave different samples")
+	}
+}
+
+func TestRowListScattering(t *testing.T) {
+	rows := "pdb,binder_length\na.pdb,50\nb.pdb,\n"
+	inputVectors := map[string][]interface{}{"speedup": {true, false}}
+	keys, inputsList, err := scatter("rowList", nil, inputVectors, ScatteringOptions{Rows: rows})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"binder_length", "pdb", "speedup"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys = %v, want %v", keys, expected)
+	}
+	expected := [][]interface{}{
+		{"50", "a.pdb", true},
+		{"50", "a.pdb", false},
+		{"", "b.pdb", true},
+		{"", "b.pdb", false},
+	}
+	if !reflect.DeepEqual(inputsList, expected) {
+		t.Errorf("inputs = %v, want %v", inputsList, expected)
+	}
+}
+
+func TestZipCrossScattering(t *testing.T) {
+	inputVectors := map[string][]interface{}{
+		"pdb":           {"a.pdb", "b.pdb"},
+		"target_chain":  {"A", "B"},
+		"binder_length": {float64(50), float64(60)},
+	}
+	_, inputsList, err := scatter("zipCross", nil, inputVectors, ScatteringOptions{Groups: [][]string{{"target_chain", "pdb"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{float64(50), "a.pdb", "A"},
+		{float64(50), "b.pdb", "B"},
+		{float64(60), "a.pdb", "A"},
+		{float64(60), "b.pdb", "B"},
+	}
+	if !reflect.DeepEqual(inputsList, expected) {
+		t.Errorf("inputs = %v, want %v", inputsList, expected)
+	}
+}
+
+func TestScatteringRejectsInvalidOptions(t *testing.T) {
+	inputVectors := map[string][]interface{}{
+		"pdb":          {"a.pdb", "b.pdb"},
+		"target_chain": {"A"},
+	}
+	modelInputs := map[string]ModelInput{"hotspots": {Type: "number", Array: true}}
+	cases := map[string]struct {
+		method  string
+		options ScatteringOptions
+	}{
+		"unknown method":             {"gridSearch", ScatteringOptions{}},
+		"dot product lengths":        {"dotProduct", ScatteringOptions{}},
+		"no samples":                 {"randomSample", ScatteringOptions{}},
+		"too many samples":           {"randomSample", ScatteringOptions{Samples: maxRangeValues + 1}},
+		"too many hypercube samples": {"latinHypercube", ScatteringOptions{Samples: maxRangeValues + 1, Ranges: map[string]ScatteringRange{"x": {Max: 1}}}},
+		"array column":               {"rowList", ScatteringOptions{Rows: "hotspots\n1"}},
+		"no ranges":                  {"latinHypercube", ScatteringOptions{Samples: 3}},
+		"range in kwargs":            {"latinHypercube", ScatteringOptions{Samples: 3, Ranges: map[string]ScatteringRange{"pdb": {Max: 1}}}},
+		"empty range":                {"latinHypercube", ScatteringOptions{Samples: 3, Ranges: map[string]ScatteringRange{"x": {Min: 2, Max: 1}}}},
+		"column in kwargs":           {"rowList", ScatteringOptions{Rows: "pdb\nc.pdb"}},
+		"ragged rows":                {"rowList", ScatteringOptions{Rows: "x,y\n1"}},
+		"no rows":                    {"rowList", ScatteringOptions{Rows: "x,y"}},
+		"group lengths":              {"zipCross", ScatteringOptions{Groups: [][]string{{"pdb", "target_chain"}}}},
+		"input in two groups":        {"zipCross", ScatteringOptions{Groups: [][]string{{"pdb"}, {"pdb"}}}},
+		"group unknown inputs":       {"zipCross", ScatteringOptions{Groups: [][]string{{"hotspots"}}}},
+	}
+	for name, c := range cases {
+		if _, _, err := scatter(c.method, modelInputs, inputVectors, c.options); !errors.Is(err, ErrInvalidScattering) {
+			t.Errorf("%s: expected ErrInvalidScattering, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package ipwl
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labdao/plex/gateway/models"
+)
+
+// ModelSchema is the JSON Schema of model manifests. Validate checks what it
+// describes and the rules across fields a schema cannot express.
+//
+//go:embed model.schema.json
+var ModelSchema []byte
+
+var (
+	inputTypes  = []string{"file", "File", "string", "number", "boolean"}
+	outputTypes = []string{"file", "File", "Array", "number"}
+	modelTypes  = []ModelType{"", ModelTypeRay, ModelTypeExec, ModelTypeBacalhau}
+	jobTypes    = []models.JobType{"", models.JobTypeJob, models.JobTypeService}
+)
+
+// ValidationError is a problem with one field of a manifest. Path points to
+// the field, e.g. inputs.binder_length.max.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors are all problems found in a manifest, ordered by path.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ParseModel decodes and validates a manifest. Errors are ValidationErrors.
+func ParseModel(data []byte) (Model, error) {
+	var model Model
+	if err := json.Unmarshal(data, &model); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		var syntaxErr *json.SyntaxError
+		switch {
+		case errors.As(err, &typeErr):
+			return model, ValidationErrors{{Path: typeErr.Field, Message: fmt.Sprintf("must be %s, got %s", jsonTypeName(typeErr.Type.String()), typeErr.Value)}}
+		case errors.As(err, &syntaxErr):
+			return model, ValidationErrors{{Message: fmt.Sprintf("invalid JSON at offset %d: %v", syntaxErr.Offset, err)}}
+		default:
+			return model, ValidationErrors{{Message: err.Error()}}
+		}
+	}
+	return model, model.Validate()
+}
+
+func jsonTypeName(goType string) string {
+	switch {
+	case goType == "string" || strings.HasSuffix(goType, "Type"):
+		return "a string"
+	case goType == "bool":
+		return "a boolean"
+	case strings.HasPrefix(goType, "int"):
+		return "an integer"
+	case strings.HasPrefix(goType, "float"):
+		return "a number"
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// Validate checks a manifest before it is stored, so that mistakes surface
+// to the uploader instead of failing jobs later on.
+func (m Model) Validate() error {
+	var errs ValidationErrors
+	add := func(path string, format string, args ...any) {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(m.Name) == "" {
+		add("name", "is required")
+	}
+	if m.Version != "" {
+		if _, err := ParseVersion(m.Version); err != nil {
+			add("version", "%v", err)
+		}
+	}
+	if !contains(modelTypes, m.ModelType) {
+		add("modelType", "must be one of ray, exec or bacalhau, got %q", m.ModelType)
+	}
+	if !contains(jobTypes, m.JobType) {
+		add("jobType", "must be job or service, got %q", m.JobType)
+	}
+	if m.JobType == models.JobTypeService && m.RayEndpoint == "" {
+		add("rayEndpoint", "is required for service jobs")
+	}
+	if m.MaxRunningTime < 0 {
+		add("maxRunningTime", "must not be negative")
+	}
+	if m.ComputeCost < 0 {
+		add("computeCost", "must not be negative")
+	}
+	if m.Resources.CPU < 0 {
+		add("resources.cpu", "must not be negative")
+	}
+	if m.Resources.MemoryGb < 0 {
+		add("resources.memoryGb", "must not be negative")
+	}
+	if m.Resources.GPU < 0 {
+		add("resources.gpu", "must not be negative")
+	}
+
+	for name, input := range m.Inputs {
+		for _, err := range input.validate() {
+			add("inputs."+name+pathSuffix(err.Path), "%s", err.Message)
+		}
+	}
+	for name, output := range m.Outputs {
+		path := "outputs." + name
+		if !contains(outputTypes, output.Type) {
+			add(path+".type", "must be one of %s, got %q", strings.Join(outputTypes, ", "), output.Type)
+		}
+		validateGlobs(path+".glob", output.Glob, add)
+	}
+
+	if (m.XAxis == "") != (m.YAxis == "") {
+		add("xAxis", "xAxis and yAxis must be set together")
+	}
+	for path, axis := range map[string]string{"xAxis": m.XAxis, "yAxis": m.YAxis} {
+		if axis == "" {
+			continue
+		}
+		output, ok := m.Outputs[axis]
+		if !ok {
+			add(path, "%q is not an output of the model", axis)
+		} else if output.Type != "number" {
+			add(path, "output %q must be of type number, got %q", axis, output.Type)
+		}
+	}
+
+	if policy := m.RetryPolicy; policy != nil {
+		for i, code := range policy.StatusCodes {
+			if code < 100 || code > 599 {
+				add(fmt.Sprintf("retryPolicy.statusCodes[%d]", i), "%d is not an HTTP status code", code)
+			}
+		}
+		if policy.MaxRetries != nil && *policy.MaxRetries < 0 {
+			add("retryPolicy.maxRetries", "must not be negative")
+		}
+		if policy.InitialBackoffSeconds != nil && *policy.InitialBackoffSeconds < 0 {
+			add("retryPolicy.initialBackoffSeconds", "must not be negative")
+		}
+		if policy.BackoffMultiplier != nil && *policy.BackoffMultiplier < 0 {
+			add("retryPolicy.backoffMultiplier", "must not be negative")
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// validate checks a single input, paths are relative to it.
+func (input ModelInput) validate() ValidationErrors {
+	var errs ValidationErrors
+	add := func(path string, format string, args ...any) {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !contains(inputTypes, input.Type) {
+		add("type", "must be one of %s, got %q", strings.Join(inputTypes, ", "), input.Type)
+	}
+	validateGlobs("glob", input.Glob, add)
+
+	var lower, upper *float64
+	if input.Type != "number" {
+		if input.Min != "" {
+			add("min", "only applies to number inputs")
+		}
+		if input.Max != "" {
+			add("max", "only applies to number inputs")
+		}
+	} else {
+		var err error
+		if lower, err = parseBound(input.Min); err != nil {
+			add("min", "must be a number, got %q", input.Min)
+		}
+		if upper, err = parseBound(input.Max); err != nil {
+			add("max", "must be a number, got %q", input.Max)
+		}
+		if lower != nil && upper != nil && *lower > *upper {
+			add("min", "must not be greater than max (%s > %s)", input.Min, input.Max)
+		}
+	}
+
+	if isEmptyDefault(input.Default) {
+		return errs
+	}
+	// the default of an array input is a list of values
+	defaults := map[string]interface{}{"default": input.Default}
+	if input.Array {
+		items, ok := input.Default.([]interface{})
+		if !ok {
+			add("default", "must be a list for array inputs, got %v", input.Default)
+			return errs
+		}
+		defaults = make(map[string]interface{}, len(items))
+		for i, item := range items {
+			defaults[fmt.Sprintf("default[%d]", i)] = item
+		}
+	}
+	for path, value := range defaults {
+		switch input.Type {
+		case "boolean":
+			if _, ok := value.(bool); !ok {
+				add(path, "must be a boolean, got %v", value)
+			}
+		case "number":
+			number, ok := numberValue(value)
+			switch {
+			case !ok:
+				add(path, "must be a number, got %v", value)
+			case lower != nil && number < *lower:
+				add(path, "%v is less than min %s", value, input.Min)
+			case upper != nil && number > *upper:
+				add(path, "%v is greater than max %s", value, input.Max)
+			}
+		}
+	}
+	return errs
+}
+
+func validateGlobs(fieldPath string, globs []string, add func(string, string, ...any)) {
+	for i, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			add(fmt.Sprintf("%s[%d]", fieldPath, i), "invalid pattern %q", glob)
+		}
+	}
+}
+
+// parseBound parses min or max, which are empty when there is no bound.
+func parseBound(value string) (*float64, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// numberValue accepts numbers and numeric strings, like processInputValue.
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+// Manifests use "" for inputs without a default.
+func isEmptyDefault(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+func pathSuffix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return "." + path
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
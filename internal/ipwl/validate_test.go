@@ -0,0 +1,166 @@
+package ipwl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseModelAcceptsShippedManifests(t *testing.T) {
+	paths, err := filepath.Glob("../../models/*/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == "user_input.json" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseModel(data); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestParseModelReportsPaths(t *testing.T) {
+	manifest := `{
+		"name": "binder",
+		"version": "1.0",
+		"jobType": "service",
+		"modelType": "docker",
+		"xAxis": "plddt",
+		"yAxis": "pdb",
+		"inputs": {
+			"binder_length": {"type": "number", "min": "100", "max": "10", "default": "50"},
+			"pdb": {"type": "protein", "glob": ["*.pdb", "[a-"]},
+			"speedup": {"type": "boolean", "default": "yes"}
+		},
+		"outputs": {
+			"pdb": {"type": "File", "glob": ["*.pdb"]}
+		},
+		"resources": {"gpu": -1}
+	}`
+	_, err := ParseModel([]byte(manifest))
+
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	var paths []string
+	for _, e := range validationErrors {
+		paths = append(paths, e.Path)
+	}
+	expected := []string{
+		"inputs.binder_length.default",
+		"inputs.binder_length.min",
+		"inputs.pdb.glob[1]",
+		"inputs.pdb.type",
+		"inputs.speedup.default",
+		"modelType",
+		"rayEndpoint",
+		"resources.gpu",
+		"version",
+		"xAxis",
+		"yAxis",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths = %v, want %v", paths, expected)
+	}
+}
+
+func TestParseModelReportsTypeErrors(t *testing.T) {
+	_, err := ParseModel([]byte(`{"name": "binder", "inputs": {"binder_length": {"type": "number", "max": 1000}}}`))
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) || len(validationErrors) != 1 {
+		t.Fatalf("expected one validation error, got %v", err)
+	}
+	if got := validationErrors[0]; got.Path != "inputs.binder_length.max" || got.Message != "must be a string, got number" {
+		t.Errorf("unexpected error %+v", got)
+	}
+}
+
+// The schema is published for manifest authors, it must allow what Validate
+// allows.
+func TestModelSchemaMatchesValidation(t *testing.T) {
+	var schema struct {
+		Properties map[string]struct {
+			Enum []string `json:"enum"`
+		} `json:"properties"`
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(ModelSchema, &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+
+	var modelTypeNames, jobTypeNames []string
+	for _, modelType := range modelTypes {
+		modelTypeNames = append(modelTypeNames, string(modelType))
+	}
+	for _, jobType := range jobTypes {
+		jobTypeNames = append(jobTypeNames, string(jobType))
+	}
+	checks := map[string][2][]string{
+		"modelType":   {schema.Properties["modelType"].Enum, modelTypeNames},
+		"jobType":     {schema.Properties["jobType"].Enum, jobTypeNames},
+		"input.type":  {schema.Defs["input"].Properties["type"].Enum, inputTypes},
+		"output.type": {schema.Defs["output"].Properties["type"].Enum, outputTypes},
+	}
+	for name, check := range checks {
+		if !reflect.DeepEqual(check[0], check[1]) {
+			t.Errorf("%s: schema allows %v, validation allows %v", name, check[0], check[1])
+		}
+	}
+}
+
+func TestParseModelChecksArrayDefaults(t *testing.T) {
+	manifest := `{
+		"name": "binder",
+		"inputs": {
+			"hotspots": {"type": "number", "array": true, "max": "100", "default": ["5", 500]},
+			"pdbs": {"type": "file", "array": true, "default": "target.pdb"},
+			"chains": {"type": "string", "array": true, "default": ["A", "B"]}
+		}
+	}`
+	_, err := ParseModel([]byte(manifest))
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	var paths []string
+	for _, e := range validationErrors {
+		paths = append(paths, e.Path)
+	}
+	if expected := []string{"inputs.hotspots.default[1]", "inputs.pdbs.default"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths = %v, want %v", paths, expected)
+	}
+}
+
+func TestParseModelKeepsZeroRetryPolicyFields(t *testing.T) {
+	model, err := ParseModel([]byte(`{"name": "binder", "retryPolicy": {"maxRetries": 0}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := model.RetryPolicy
+	if policy.MaxRetries == nil || *policy.MaxRetries != 0 {
+		t.Errorf("maxRetries = %v, want 0", policy.MaxRetries)
+	}
+	if policy.InitialBackoffSeconds != nil || policy.BackoffMultiplier != nil {
+		t.Errorf("missing fields should stay unset, got %+v", policy)
+	}
+
+	_, err = ParseModel([]byte(`{"name": "binder", "retryPolicy": {"maxRetries": -1}}`))
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) || len(validationErrors) != 1 || validationErrors[0].Path != "retryPolicy.maxRetries" {
+		t.Errorf("expected an error for retryPolicy.maxRetries, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package ipwl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultModelVersion is the version of manifests that do not declare one.
+const DefaultModelVersion = "1.0.0"
+
+// LatestModelVersion resolves to the highest version of a model that is not
+// deprecated.
+const LatestModelVersion = "latest"
+
+// Version is a semantic version, MAJOR.MINOR.PATCH with an optional
+// pre-release, e.g. 1.4.0-rc.1. Build metadata is not allowed, two versions
+// must not differ in it only.
+type Version struct {
+	Major, Minor, Patch int
+	PreRelease          []string
+}
+
+// ParseVersion parses a semantic version as described at https://semver.org.
+func ParseVersion(value string) (Version, error) {
+	var v Version
+	core, preRelease, hasPreRelease := strings.Cut(value, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a semantic version like 1.2.3", value)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := parseVersionNumber(part)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a semantic version like 1.2.3", value)
+		}
+		*numbers[i] = n
+	}
+	if hasPreRelease {
+		v.PreRelease = strings.Split(preRelease, ".")
+		for _, identifier := range v.PreRelease {
+			if !validPreRelease(identifier) {
+				return v, fmt.Errorf("%q has an invalid pre-release %q", value, preRelease)
+			}
+		}
+	}
+	return v, nil
+}
+
+func parseVersionNumber(part string) (int, error) {
+	if part == "" || (len(part) > 1 && part[0] == '0') {
+		return 0, strconv.ErrSyntax
+	}
+	for _, c := range part {
+		if c < '0' || c > '9' {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	return strconv.Atoi(part)
+}
+
+func validPreRelease(identifier string) bool {
+	if identifier == "" {
+		return false
+	}
+	numeric := true
+	for _, c := range identifier {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-':
+			numeric = false
+		default:
+			return false
+		}
+	}
+	return !numeric || len(identifier) == 1 || identifier[0] != '0'
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v orders before, the same as or after other.
+// Pre-releases order before their release.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if c := compareInts(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.PreRelease) == 0 && len(other.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(other.PreRelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		if c := comparePreRelease(v.PreRelease[i], other.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.PreRelease), len(other.PreRelease))
+}
+
+// Numeric identifiers compare numerically and before alphanumeric ones.
+func comparePreRelease(a, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package ipwl
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, valid := range []string{"0.0.1", "1.2.3", "10.20.30", "1.0.0-alpha", "1.0.0-rc.1", "1.0.0-x-y.7"} {
+		v, err := ParseVersion(valid)
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %v", valid, err)
+			continue
+		}
+		if v.String() != valid {
+			t.Errorf("ParseVersion(%q).String() = %q", valid, v.String())
+		}
+	}
+	for _, invalid := range []string{"", "1", "1.2", "1.2.3.4", "v1.2.3", "01.2.3", "1.2.-3", "1.2.3-", "1.2.3-01", "1.2.3-a..b", "1.2.3+build", "latest"} {
+		if _, err := ParseVersion(invalid); err == nil {
+			t.Errorf("ParseVersion(%q) expected an error", invalid)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// in ascending order, from the semver spec
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	versions := make([]Version, len(ordered))
+	for i := range ordered {
+		// parse in reverse so sorting has something to do
+		v, err := ParseVersion(ordered[len(ordered)-1-i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions[i] = v
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Compare(versions[j]) < 0 })
+	for i, v := range versions {
+		if v.String() != ordered[i] {
+			t.Errorf("position %d: got %s, want %s", i, v, ordered[i])
+		}
+	}
+	if c := versions[3].Compare(versions[3]); c != 0 {
+		t.Errorf("Compare with itself = %d", c)
+	}
+}
@@ -0,0 +1,225 @@
+package localexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateStopped   State = "stopped"
+)
+
+// ErrNotFound is returned for process IDs the runner has never started, e.g.
+// after the gateway restarted.
+var ErrNotFound = errors.New("local process not found")
+
+// Runner starts model entrypoints as local processes, mimicking the contract
+// of a Ray job: inputs are passed through REQUEST_UUID and RAY_JOB_INPUTS and
+// response documents are written as JSON files into OUTPUT_DIR.
+type Runner struct {
+	workDir   string
+	outputDir string
+
+	mu    sync.Mutex
+	procs map[string]*process
+}
+
+type process struct {
+	cmd     *exec.Cmd
+	logs    *logBuffer
+	done    chan struct{}
+	err     error
+	stopped bool
+}
+
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func NewRunner(workDir, outputDir string) *Runner {
+	return &Runner{
+		workDir:   workDir,
+		outputDir: outputDir,
+		procs:     make(map[string]*process),
+	}
+}
+
+func (r *Runner) command(ctx context.Context, id string, entrypoint string, env map[string]string) (*exec.Cmd, error) {
+	if strings.TrimSpace(entrypoint) == "" {
+		return nil, fmt.Errorf("no entrypoint defined for process %s", id)
+	}
+	outputDir := filepath.Join(r.outputDir, id)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", entrypoint)
+	cmd.Dir = r.workDir
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcess(cmd) }
+	cmd.Env = append(os.Environ(), "OUTPUT_DIR="+outputDir)
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	return cmd, nil
+}
+
+// Start launches the entrypoint in the background and returns immediately.
+func (r *Runner) Start(id string, entrypoint string, env map[string]string) error {
+	cmd, err := r.command(context.Background(), id, entrypoint, env)
+	if err != nil {
+		return err
+	}
+	logs := &logBuffer{}
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+
+	r.mu.Lock()
+	if _, exists := r.procs[id]; exists {
+		r.mu.Unlock()
+		return fmt.Errorf("process %s already exists", id)
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	p := &process{cmd: cmd, logs: logs, done: make(chan struct{})}
+	r.procs[id] = p
+	r.mu.Unlock()
+
+	slog.Info("Started local process", "ray_job_id", id, "pid", cmd.Process.Pid)
+	go func() {
+		err := cmd.Wait()
+		r.mu.Lock()
+		p.err = err
+		r.mu.Unlock()
+		close(p.done)
+	}()
+	return nil
+}
+
+// Run executes the entrypoint synchronously and returns whatever it printed
+// on stdout. Stderr is kept as the process logs.
+func (r *Runner) Run(ctx context.Context, id string, entrypoint string, env map[string]string) ([]byte, error) {
+	cmd, err := r.command(ctx, id, entrypoint, env)
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	logs := &logBuffer{}
+	cmd.Stdout = &stdout
+	cmd.Stderr = logs
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &process{cmd: cmd, logs: logs, done: make(chan struct{})}
+	r.mu.Lock()
+	r.procs[id] = p
+	r.mu.Unlock()
+
+	err = cmd.Wait()
+	r.mu.Lock()
+	p.err = err
+	r.mu.Unlock()
+	close(p.done)
+	return stdout.Bytes(), err
+}
+
+func (r *Runner) get(id string) (*process, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.procs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p, nil
+}
+
+func (r *Runner) State(id string) (State, error) {
+	p, err := r.get(id)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case <-p.done:
+	default:
+		return StateRunning, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.stopped {
+		return StateStopped, nil
+	}
+	if p.err != nil {
+		return StateFailed, nil
+	}
+	return StateSucceeded, nil
+}
+
+func (r *Runner) Stop(id string) error {
+	p, err := r.get(id)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	r.mu.Lock()
+	p.stopped = true
+	r.mu.Unlock()
+	if err := killProcess(p.cmd); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-p.done
+	return nil
+}
+
+func (r *Runner) Logs(id string) (string, error) {
+	p, err := r.get(id)
+	if err != nil {
+		return "", err
+	}
+	return p.logs.String(), nil
+}
+
+// Outputs lists the JSON response documents the process wrote so far, in
+// lexical order.
+func (r *Runner) Outputs(id string) ([]string, error) {
+	outputDir := filepath.Join(r.outputDir, id)
+	files, err := filepath.Glob(filepath.Join(outputDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
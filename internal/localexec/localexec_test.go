@@ -0,0 +1,91 @@
+package localexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForState(t *testing.T, r *Runner, id string, want State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		state, err := r.State(id)
+		if err != nil {
+			t.Fatalf("Error in State: %v", err)
+		}
+		if state == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Process %s never reached state %s", id, want)
+}
+
+func TestStartWritesOutputs(t *testing.T) {
+	r := NewRunner(".", t.TempDir())
+	err := r.Start("job-1", `echo "$RAY_JOB_INPUTS" > "$OUTPUT_DIR/$REQUEST_UUID-response.json"; echo done`, map[string]string{
+		"REQUEST_UUID":   "job-1",
+		"RAY_JOB_INPUTS": `{"uuid": "job-1"}`,
+	})
+	if err != nil {
+		t.Fatalf("Error in Start: %v", err)
+	}
+	waitForState(t, r, "job-1", StateSucceeded)
+
+	outputs, err := r.Outputs("job-1")
+	if err != nil {
+		t.Fatalf("Error in Outputs: %v", err)
+	}
+	if len(outputs) != 1 || filepath.Base(outputs[0]) != "job-1-response.json" {
+		t.Fatalf("Unexpected outputs: %v", outputs)
+	}
+	data, err := os.ReadFile(outputs[0])
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != `{"uuid": "job-1"}` {
+		t.Errorf("Unexpected output content: %s", data)
+	}
+
+	logs, err := r.Logs("job-1")
+	if err != nil {
+		t.Fatalf("Error in Logs: %v", err)
+	}
+	if strings.TrimSpace(logs) != "done" {
+		t.Errorf("Unexpected logs: %q", logs)
+	}
+}
+
+func TestStopAndFailure(t *testing.T) {
+	r := NewRunner(".", t.TempDir())
+	if err := r.Start("sleeper", "sleep 30", nil); err != nil {
+		t.Fatalf("Error in Start: %v", err)
+	}
+	if err := r.Stop("sleeper"); err != nil {
+		t.Fatalf("Error in Stop: %v", err)
+	}
+	waitForState(t, r, "sleeper", StateStopped)
+
+	if err := r.Start("failing", "exit 3", nil); err != nil {
+		t.Fatalf("Error in Start: %v", err)
+	}
+	waitForState(t, r, "failing", StateFailed)
+
+	if _, err := r.State("unknown"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	r := NewRunner(".", t.TempDir())
+	output, err := r.Run(context.Background(), "service", `echo "{\"uuid\": \"$REQUEST_UUID\"}"`, map[string]string{"REQUEST_UUID": "service"})
+	if err != nil {
+		t.Fatalf("Error in Run: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != `{"uuid": "service"}` {
+		t.Errorf("Unexpected output: %s", output)
+	}
+}
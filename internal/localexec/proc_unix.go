@@ -0,0 +1,18 @@
+//go:build !windows
+
+package localexec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup puts the entrypoint in its own process group so that
+// killProcess also takes down whatever the shell spawned.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
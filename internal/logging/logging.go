@@ -0,0 +1,56 @@
+// Package logging sets up structured logging with log/slog. Loggers carry
+// correlation IDs such as request and job IDs as attributes, and everything
+// they write passes through a handler that redacts secrets.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey string
+
+const loggerContextKey contextKey = "logger"
+
+// Setup installs the default logger, configured by LOG_LEVEL (debug, info,
+// warn, error) and LOG_FORMAT (text or json). Output of the standard log
+// package goes through it as well.
+func Setup() {
+	slog.SetDefault(New(os.Stderr, ParseLevel(os.Getenv("LOG_LEVEL")), strings.ToLower(os.Getenv("LOG_FORMAT")) == "json"))
+}
+
+func New(w io.Writer, level slog.Level, json bool) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if json {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(NewRedactingHandler(handler))
+}
+
+// ParseLevel returns the level with the given name, info if it is unknown.
+func ParseLevel(name string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// WithLogger returns a context that carries logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger of ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
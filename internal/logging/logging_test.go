@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Auth header: Bearer abc.def-123", "Auth header: Bearer [REDACTED]"},
+		{"key sk_live_51HxYz and whsec_abc123", "key [REDACTED] and [REDACTED]"},
+		{"Token string: eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl", "Token string: [REDACTED]"},
+		{"http://gw/jobs/callback/1?token=deadbeef&x=1", "http://gw/jobs/callback/1?token=[REDACTED]&x=1"},
+		{"host=db user=labdao password=hunter2 dbname=labdao", "host=db user=labdao password=[REDACTED] dbname=labdao"},
+		{"postgres://labdao:hunter2@db:5432/labdao", "postgres://labdao:[REDACTED]@db:5432/labdao"},
+		{"nothing to hide here", "nothing to hide here"},
+	}
+	for _, test := range tests {
+		if actual := Redact(test.input); actual != test.expected {
+			t.Errorf("Redact(%q) = %q, expected %q", test.input, actual, test.expected)
+		}
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo, false).With("api_key", "plex_k_123", "request_id", "r1")
+	logger.Info("Calling https://x/?token=abc",
+		"stripe_secret_key", "sk_test_123",
+		"token_id", 42,
+		"error", errors.New("bad Bearer xyz"),
+		slog.Group("user", "password", "pw", "wallet", "0xabc"),
+	)
+	logger.Debug("not enabled")
+
+	output := buf.String()
+	for _, secret := range []string{"plex_k_123", "sk_test_123", "token=abc", "Bearer xyz", "pw "} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Output contains secret %q: %s", secret, output)
+		}
+	}
+	for _, expected := range []string{"request_id=r1", "token_id=42", "user.wallet=0xabc", "error=\"bad Bearer [REDACTED]\""} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output is missing %q: %s", expected, output)
+		}
+	}
+	if strings.Contains(output, "not enabled") {
+		t.Errorf("Debug line was written at info level: %s", output)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Errorf("Expected the default logger for a context without one")
+	}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Errorf("Expected the logger stored in the context")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if ParseLevel("debug") != slog.LevelDebug || ParseLevel("WARN") != slog.LevelWarn || ParseLevel("") != slog.LevelInfo {
+		t.Errorf("Unexpected levels parsed")
+	}
+}
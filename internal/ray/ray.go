@@ -2,79 +2,170 @@ package ray
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
 
+	"github.com/gorilla/websocket"
 	"github.com/labdao/plex/gateway/models"
 	"github.com/labdao/plex/internal/ipwl"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
 var rayClient *http.Client
+var tracer = otel.Tracer("github.com/labdao/plex/internal/ray")
 var once sync.Once
 
-func GetRayApiHost() string {
-	// For colabfold local testing set this env var to http://colabfold-service:<PORT>
-	rayApiHost, exists := os.LookupEnv("RAY_API_HOST")
-	if exists {
-		return rayApiHost
-	} else {
-		return "http://localhost:8000" // Default Ray API host
+// ErrJobNotFound is returned when the Ray dashboard has no record of a job.
+var ErrJobNotFound = errors.New("ray job not found")
+
+// Settings tell where the Ray cluster is and how jobs report back. They are
+// read from the environment unless the gateway passes its own to Configure.
+type Settings struct {
+	APIHost         string
+	JobAPIHost      string
+	CallbackBaseURL string
+	CallbackSecret  string
+}
+
+var settings = settingsFromEnv()
+
+func settingsFromEnv() Settings {
+	s := Settings{
+		APIHost:         "http://localhost:8000", // Default Ray API host
+		JobAPIHost:      "http://localhost:8265", // Default Ray API host
+		CallbackBaseURL: os.Getenv("JOB_CALLBACK_BASE_URL"),
+		CallbackSecret:  os.Getenv("JOB_CALLBACK_SECRET"),
 	}
+	// For colabfold local testing set these env vars to http://colabfold-service:<PORT>
+	if rayApiHost, exists := os.LookupEnv("RAY_API_HOST"); exists {
+		s.APIHost = rayApiHost
+	}
+	if rayJobApiHost, exists := os.LookupEnv("RAY_JOB_API_HOST"); exists {
+		s.JobAPIHost = rayJobApiHost
+	}
+	return s
+}
+
+// Configure replaces the settings read from the environment. It must be
+// called before any job is submitted.
+func Configure(s Settings) {
+	settings = s
+}
+
+func GetRayApiHost() string {
+	return settings.APIHost
 }
 
 func GetRayJobApiHost() string {
-	// For colabfold local testing set this env var to http://colabfold-service:<PORT>
-	rayApiHost, exists := os.LookupEnv("RAY_JOB_API_HOST")
-	if exists {
-		return rayApiHost
-	} else {
-		return "http://localhost:8265" // Default Ray API host
+	return settings.JobAPIHost
+}
+
+// JobCallbackToken signs a Ray job ID with the callback secret, so that a job
+// can report its own completion to the gateway without a user session.
+func JobCallbackToken(rayJobID string) string {
+	mac := hmac.New(sha256.New, []byte(settings.CallbackSecret))
+	mac.Write([]byte(rayJobID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// JobCallbackSignatureHeader carries the JobCallbackToken of a callback. A
+// header keeps the token out of the access logs of proxies on the way.
+const JobCallbackSignatureHeader = "X-Callback-Signature"
+
+// JobCallbackURL is the URL a job calls when it finishes. It is empty unless
+// both the callback base URL and secret are set.
+func JobCallbackURL(rayJobID string) string {
+	if settings.CallbackBaseURL == "" || settings.CallbackSecret == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/jobs/callback/%s", strings.TrimSuffix(settings.CallbackBaseURL, "/"), rayJobID)
+}
+
+// JobCallbackEnv returns the env vars that let a job call back: the URL in
+// JOB_CALLBACK_URL and the value of the X-Callback-Signature header in
+// JOB_CALLBACK_SIGNATURE. It is empty if callbacks are not enabled.
+func JobCallbackEnv(rayJobID string) map[string]string {
+	callbackURL := JobCallbackURL(rayJobID)
+	if callbackURL == "" {
+		return nil
+	}
+	return map[string]string{
+		"JOB_CALLBACK_URL":       callbackURL,
+		"JOB_CALLBACK_SIGNATURE": JobCallbackToken(rayJobID),
 	}
 }
 
+// CallbacksEnabled reports whether jobs are given a callback URL.
+func CallbacksEnabled() bool {
+	return settings.CallbackSecret != ""
+}
+
 // Prevents race conditions with Ray Client
 func GetRayClient() *http.Client {
 	once.Do(func() {
-		rayClient = &http.Client{}
+		// Requests made as part of a trace, like job submissions, get a
+		// client span and pass the trace on in the traceparent header
+		rayClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport,
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return trace.SpanContextFromContext(r.Context()).IsValid()
+			}),
+		)}
 	})
 	return rayClient
 }
 
-func handleSingleElementInput(value interface{}) (string, error) {
+// handleSingleElementInput converts the value of an input to the strings
+// of the Ray payload. The values of array inputs become lists of strings.
+func handleSingleElementInput(value interface{}) (interface{}, error) {
 	switch v := value.(type) {
 	case string:
 		return v, nil
-	case float64, int, int64:
-		// Convert numeric values to string
+	case float64, int, int64, bool:
+		// Convert numeric and boolean values to string
 		return fmt.Sprintf("%v", v), nil
 	case nil:
 		return "", nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return nil, fmt.Errorf("item %d: nested lists are not supported", i)
+			}
+			adjustedItem, err := handleSingleElementInput(item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			items[i] = adjustedItem.(string)
+		}
+		return items, nil
 	default:
 		return "", fmt.Errorf("unsupported type: %T", v)
 	}
 }
 
-func CreateRayJob(job *models.Job, modelPath string, rayJobID string, inputs map[string]interface{}, db *gorm.DB) (*http.Response, error) {
-	model, _, err := ipwl.ReadModelConfig(modelPath, db)
-	if err != nil {
-		return nil, err
-	}
-	var jsonBytes []byte
-	var rayServiceURL string
-
-	// Validate input keys
-	err = validateInputKeys(inputs, model.Inputs)
-	if err != nil {
-		return nil, err
-	}
-
+// AdjustInputs flattens the single-element input slices handed over by the
+// queue into the values expected by the Ray payload. The element is the
+// value of the job, which is itself a list for array inputs, so a list is
+// only ever unwrapped once.
+func AdjustInputs(inputs map[string]interface{}) (map[string]interface{}, error) {
 	adjustedInputs := make(map[string]interface{})
 	for key, value := range inputs {
 		switch v := value.(type) {
@@ -92,7 +183,7 @@ func CreateRayJob(job *models.Job, modelPath string, rayJobID string, inputs map
 			} else {
 				return nil, fmt.Errorf("expected a single-element slice for key %s, got: %v", key, v)
 			}
-		case string, float64, int, nil:
+		case string, float64, int, bool, nil:
 			adjustedValue, err := handleSingleElementInput(value)
 			if err != nil {
 				return nil, fmt.Errorf("invalid input for key %s: %v", key, err)
@@ -102,18 +193,75 @@ func CreateRayJob(job *models.Job, modelPath string, rayJobID string, inputs map
 			return nil, fmt.Errorf("unsupported type for key %s: %T", key, value)
 		}
 	}
+	return adjustedInputs, nil
+}
+
+// CreateRayJob sends a job to the Ray cluster. Its trace context is passed on
+// in the traceparent header by the client and, for batch jobs, in the TRACEPARENT variable
+// of the runtime_env so that model code can join the trace.
+func CreateRayJob(ctx context.Context, job *models.Job, modelPath string, rayJobID string, inputs map[string]interface{}, db *gorm.DB) (resp *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "ray.CreateRayJob", trace.WithAttributes(
+		attribute.Int("job_id", int(job.ID)),
+		attribute.String("ray_job_id", rayJobID),
+		attribute.String("job_type", string(job.JobType)),
+	))
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	model, _, err := ipwl.ReadModelConfig(ctx, modelPath, db)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newRayRequest(ctx, job, model, rayJobID, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request to the Ray service
+	client := GetRayClient()
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// newRayRequest builds the request that submits a job: the inputs for a Ray
+// service, or a job submission with the inputs in the runtime_env.
+func newRayRequest(ctx context.Context, job *models.Job, model ipwl.Model, rayJobID string, inputs map[string]interface{}) (*http.Request, error) {
+	var jsonBytes []byte
+	var rayServiceURL string
+
+	// Validate input keys
+	err := validateInputKeys(inputs, model.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustedInputs, err := AdjustInputs(inputs)
+	if err != nil {
+		return nil, err
+	}
+	logger := slog.Default().With("job_id", job.ID, "ray_job_id", rayJobID)
 	//add rayJobID to inputs
-	fmt.Printf("adding rayJobID to the adjustedInputs: %s\n", rayJobID)
 	adjustedInputs["uuid"] = rayJobID
 
 	if job.JobType == models.JobTypeService {
 		// Marshal the inputs to JSON
-		jsonBytes, err := json.Marshal(adjustedInputs)
+		jsonBytes, err = json.Marshal(adjustedInputs)
 		if err != nil {
 			return nil, err
 		}
 
-		log.Printf("Submitting Ray job with payload: %s\n", string(jsonBytes))
+		logger.Debug("Submitting Ray service request", "payload", string(jsonBytes))
 
 		rayServiceURL = GetRayApiHost() + model.RayEndpoint
 		// Create the HTTP request
@@ -123,14 +271,22 @@ func CreateRayJob(job *models.Job, modelPath string, rayJobID string, inputs map
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("Submitting Ray job with testeks inputs: %s\n", inputsJSON)
 
 		rayServiceURL = GetRayJobApiHost() + model.RayEndpoint
+		envVars := map[string]string{
+			"REQUEST_UUID":   rayJobID,
+			"RAY_JOB_INPUTS": string(inputsJSON),
+		}
+		for name, value := range JobCallbackEnv(rayJobID) {
+			envVars[name] = value
+		}
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		if traceparent := carrier.Get("traceparent"); traceparent != "" {
+			envVars["TRACEPARENT"] = traceparent
+		}
 		runtimeEnv := map[string]interface{}{
-			"env_vars": map[string]string{
-				"REQUEST_UUID":   rayJobID,
-				"RAY_JOB_INPUTS": string(inputsJSON),
-			},
+			"env_vars": envVars,
 		}
 
 		// Create the request body for the Ray job
@@ -143,22 +299,15 @@ func CreateRayJob(job *models.Job, modelPath string, rayJobID string, inputs map
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("Submitting Ray job with payload: %s\n", string(jsonBytes))
+		logger.Debug("Submitting Ray job", "payload", string(jsonBytes))
 
 	}
-	req, err := http.NewRequest("POST", rayServiceURL, bytes.NewBuffer(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", rayServiceURL, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-
-	// Send the request to the Ray service
-	client := GetRayClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return req, nil
 }
 
 func GetRayJobStatus(rayJobID string) (string, error) {
@@ -180,10 +329,13 @@ func GetRayJobStatus(rayJobID string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrJobNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("error getting job status: %s", string(body))
 	}
-	log.Printf("Ray job status response: %s\n", string(body))
+	slog.Debug("Ray job status response", "ray_job_id", rayJobID, "response", string(body))
 	var data map[string]interface{}
 	if err := json.Unmarshal(body, &data); err != nil {
 		return "", err
@@ -196,70 +348,247 @@ func GetRayJobStatus(rayJobID string) (string, error) {
 	return status.(string), nil
 }
 
-func JobIsRunning(rayJobID string) bool {
+// GetRayJobState fetches the status of a Ray job and maps it onto the
+// gateway's job states.
+func GetRayJobState(rayJobID string) (models.JobState, error) {
 	status, err := GetRayJobStatus(rayJobID)
 	if err != nil {
-		return false
+		return "", err
 	}
-	return strings.ToLower(status) == string(models.JobStateRunning)
+	return models.JobState(strings.ToLower(status)), nil
 }
 
-func JobIsPending(rayJobID string) bool {
-	status, err := GetRayJobStatus(rayJobID)
+// ListRayJobStates fetches the states of all jobs known to the Ray cluster in
+// a single request, keyed by submission ID.
+func ListRayJobStates() (map[string]models.JobState, error) {
+	rayServiceURL := GetRayJobApiHost() + "/api/jobs/"
+	req, err := http.NewRequest("GET", rayServiceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := GetRayClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error listing jobs: %s", string(body))
+	}
+	var jobs []struct {
+		SubmissionID string `json:"submission_id"`
+		Status       string `json:"status"`
 	}
-	return strings.ToLower(status) == string(models.JobStatePending)
+	if err := json.Unmarshal(body, &jobs); err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]models.JobState, len(jobs))
+	for _, job := range jobs {
+		if job.SubmissionID != "" {
+			states[job.SubmissionID] = models.JobState(strings.ToLower(job.Status))
+		}
+	}
+	return states, nil
 }
 
-func JobSucceeded(rayJobID string) bool {
-	status, err := GetRayJobStatus(rayJobID)
+// ClusterResources are the totals and current usage reported by the Ray
+// autoscaler. Memory is in GB.
+type ClusterResources struct {
+	TotalCPU      float64
+	UsedCPU       float64
+	TotalMemoryGb float64
+	UsedMemoryGb  float64
+	TotalGPU      float64
+	UsedGPU       float64
+}
+
+// GetClusterResources reads the cluster-wide resource usage from the Ray
+// dashboard's cluster status.
+func GetClusterResources() (ClusterResources, error) {
+	var resources ClusterResources
+	rayServiceURL := GetRayJobApiHost() + "/api/cluster_status"
+	req, err := http.NewRequest("GET", rayServiceURL, nil)
+	if err != nil {
+		return resources, err
+	}
+
+	client := GetRayClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return resources, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return false
+		return resources, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resources, fmt.Errorf("error getting cluster status: %s", string(body))
 	}
-	return strings.ToLower(status) == string(models.JobStateSucceeded)
+
+	// usage maps a resource name to its [used, total] pair
+	var data struct {
+		Data struct {
+			ClusterStatus struct {
+				LoadMetricsReport struct {
+					Usage map[string][]float64 `json:"usage"`
+				} `json:"loadMetricsReport"`
+			} `json:"clusterStatus"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return resources, err
+	}
+	usage := data.Data.ClusterStatus.LoadMetricsReport.Usage
+	if usage == nil {
+		return resources, fmt.Errorf("no resource usage in cluster status: %s", string(body))
+	}
+	usedAndTotal := func(name string) (float64, float64) {
+		values := usage[name]
+		if len(values) != 2 {
+			return 0, 0
+		}
+		return values[0], values[1]
+	}
+	resources.UsedCPU, resources.TotalCPU = usedAndTotal("CPU")
+	resources.UsedGPU, resources.TotalGPU = usedAndTotal("GPU")
+	usedMemory, totalMemory := usedAndTotal("memory")
+	resources.UsedMemoryGb, resources.TotalMemoryGb = usedMemory/(1<<30), totalMemory/(1<<30)
+	return resources, nil
 }
 
-func JobFailed(rayJobID string) bool {
-	status, err := GetRayJobStatus(rayJobID)
+func StopRayJob(rayJobID string) error {
+	rayServiceURL := GetRayJobApiHost() + "/api/jobs/" + rayJobID + "/stop"
+	req, err := http.NewRequest("POST", rayServiceURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := GetRayClient()
+	resp, err := client.Do(req)
 	if err != nil {
-		return false
+		return err
 	}
-	return strings.ToLower(status) == string(models.JobStateFailed)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrJobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error stopping job: %s", string(body))
+	}
+	slog.Info("Ray job stopped", "ray_job_id", rayJobID, "response", string(body))
+	return nil
 }
 
-func JobStopped(rayJobID string) bool {
-	status, err := GetRayJobStatus(rayJobID)
+func GetRayJobLogs(rayJobID string) (string, error) {
+	rayServiceURL := GetRayJobApiHost() + "/api/jobs/" + rayJobID + "/logs"
+	req, err := http.NewRequest("GET", rayServiceURL, nil)
 	if err != nil {
-		return false
+		return "", err
+	}
+
+	client := GetRayClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrJobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting job logs: %s", string(body))
+	}
+	var data struct {
+		Logs string `json:"logs"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", err
+	}
+	return data.Logs, nil
+}
+
+// TailRayJobLogs streams the logs of a job through the dashboard's
+// /logs/tail WebSocket, from the start of the logs, and hands every chunk to
+// write. It returns once Ray closes the stream after the job finished, or
+// ctx is cancelled.
+func TailRayJobLogs(ctx context.Context, rayJobID string, write func(chunk string) error) error {
+	tailURL, err := url.Parse(GetRayJobApiHost() + "/api/jobs/" + rayJobID + "/logs/tail")
+	if err != nil {
+		return err
+	}
+	if tailURL.Scheme == "https" {
+		tailURL.Scheme = "wss"
+	} else {
+		tailURL.Scheme = "ws"
+	}
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, tailURL.String(), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return ErrJobNotFound
+		}
+		return err
+	}
+	defer conn.Close()
+	// Closing the connection unblocks the read below
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := write(string(message)); err != nil {
+			return err
+		}
 	}
-	return strings.ToLower(status) == string(models.JobStateStopped)
 }
 
 func validateInputKeys(inputVectors map[string]interface{}, modelInputs map[string]ipwl.ModelInput) error {
 	for inputKey := range inputVectors {
 		if _, exists := modelInputs[inputKey]; !exists {
-			log.Printf("The argument %s is not in the model inputs.\n", inputKey)
-			log.Printf("Available keys: %v\n", modelInputs)
 			return fmt.Errorf("the argument %s is not in the model inputs", inputKey)
 		}
 	}
 	return nil
 }
 
-func SubmitRayJob(job models.Job, modelPath string, rayJobID string, inputs map[string]interface{}, db *gorm.DB) (*http.Response, error) {
-	log.Printf("Creating Ray job with modelPath: %s and inputs: %+v\n", modelPath, inputs)
-	resp, err := CreateRayJob(&job, modelPath, rayJobID, inputs, db)
+func SubmitRayJob(ctx context.Context, job models.Job, modelPath string, rayJobID string, inputs map[string]interface{}, db *gorm.DB) (*http.Response, error) {
+	logger := slog.Default().With("job_id", job.ID, "ray_job_id", rayJobID)
+	logger.Debug("Creating Ray job", "model_path", modelPath, "inputs", inputs)
+	resp, err := CreateRayJob(ctx, &job, modelPath, rayJobID, inputs, db)
 	if err != nil {
-		log.Printf("Error creating Ray job: %v\n", err)
+		logger.Error("Error creating Ray job", "error", err)
 		return nil, err
 	}
 
 	if job.JobType == models.JobTypeService {
-		log.Printf("Ray job finished with response status: %s\n", resp.Status)
+		logger.Info("Ray service request finished", "status", resp.Status)
 		return resp, nil
 	} else if job.JobType == models.JobTypeJob {
-		log.Printf("Ray job submitted with response status: %s\n", resp.Status)
+		logger.Info("Ray job submitted", "status", resp.Status)
 		return resp, nil
 	}
 	return nil, fmt.Errorf("unsupported job type: %s", job.JobType)
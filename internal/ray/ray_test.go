@@ -0,0 +1,126 @@
+package ray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/labdao/plex/gateway/models"
+	"github.com/labdao/plex/internal/ipwl"
+)
+
+func TestNewRayRequestSendsServiceInputs(t *testing.T) {
+	Configure(Settings{APIHost: "http://ray:8000", JobAPIHost: "http://ray:8265"})
+	model := ipwl.Model{
+		RayEndpoint: "/binder",
+		Inputs: map[string]ipwl.ModelInput{
+			"binder_length": {Type: "number"},
+			"hotspots":      {Type: "string", Array: true},
+		},
+	}
+	job := &models.Job{ID: 1, JobType: models.JobTypeService}
+	inputs := map[string]interface{}{
+		"binder_length": []interface{}{float64(50)},
+		"hotspots":      []interface{}{[]interface{}{}},
+	}
+
+	req, err := newRayRequest(context.Background(), job, model, "ray-job-1", inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "http://ray:8000/binder" {
+		t.Errorf("url = %s, want http://ray:8000/binder", req.URL)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid body %q: %v", body, err)
+	}
+	expected := map[string]interface{}{
+		"binder_length": "50",
+		"hotspots":      []interface{}{},
+		"uuid":          "ray-job-1",
+	}
+	if !reflect.DeepEqual(payload, expected) {
+		t.Errorf("payload = %v, want %v", payload, expected)
+	}
+}
+
+func TestNewRayRequestSubmitsBatchJobs(t *testing.T) {
+	Configure(Settings{APIHost: "http://ray:8000", JobAPIHost: "http://ray:8265"})
+	model := ipwl.Model{
+		RayEndpoint:      "/api/jobs/",
+		RayJobEntrypoint: "python main.py",
+		Inputs:           map[string]ipwl.ModelInput{"pdb": {Type: "file"}},
+	}
+	job := &models.Job{ID: 1, JobType: models.JobTypeJob}
+	inputs := map[string]interface{}{"pdb": []interface{}{"a.pdb"}}
+
+	req, err := newRayRequest(context.Background(), job, model, "ray-job-1", inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Entrypoint   string `json:"entrypoint"`
+		SubmissionID string `json:"submission_id"`
+		RuntimeEnv   struct {
+			EnvVars map[string]string `json:"env_vars"`
+		} `json:"runtime_env"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Entrypoint != "python main.py" || payload.SubmissionID != "ray-job-1" {
+		t.Errorf("unexpected submission %+v", payload)
+	}
+	if inputs := payload.RuntimeEnv.EnvVars["RAY_JOB_INPUTS"]; inputs != `{"pdb":"a.pdb","uuid":"ray-job-1"}` {
+		t.Errorf("RAY_JOB_INPUTS = %s", inputs)
+	}
+}
+
+func TestTailRayJobLogs(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/jobs/ray-job-1/logs/tail" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("step 1\n"))
+		conn.WriteMessage(websocket.TextMessage, []byte("step 2\n"))
+		// Ray closes the stream once the job finished
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer server.Close()
+	Configure(Settings{JobAPIHost: server.URL})
+
+	var chunks []string
+	err := TailRayJobLogs(context.Background(), "ray-job-1", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"step 1\n", "step 2\n"}; !reflect.DeepEqual(chunks, expected) {
+		t.Errorf("chunks = %q, want %q", chunks, expected)
+	}
+
+	err = TailRayJobLogs(context.Background(), "ray-job-2", func(string) error { return nil })
+	if !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+}
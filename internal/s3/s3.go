@@ -1,28 +1,91 @@
 package s3
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "plex_s3_operation_duration_seconds",
+	Help:    "Latency of S3 API calls by operation.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "result"})
+
+// observeOperation runs after every S3 request, including its retries.
+func observeOperation(r *request.Request) {
+	result := "success"
+	if r.Error != nil {
+		result = "error"
+	}
+	operationDuration.WithLabelValues(r.Operation.Name, result).Observe(time.Since(r.Time).Seconds())
+}
+
+// httpClient traces the requests made with a context that is part of a
+// trace, retries get a span each.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport,
+	otelhttp.WithFilter(func(r *http.Request) bool {
+		return trace.SpanContextFromContext(r.Context()).IsValid()
+	}),
+	otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return "s3 " + r.Method
+	}),
+)}
+
+var tracer = otel.Tracer("github.com/labdao/plex/internal/s3")
+
+// Settings are how to reach the bucket. They are read from the environment
+// unless the gateway passes its own to Configure.
+type Settings struct {
+	Region          string
+	Endpoint        string
+	UseSSL          bool
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+var settings = Settings{
+	Region:          os.Getenv("AWS_REGION"),
+	Endpoint:        os.Getenv("BUCKET_ENDPOINT"),
+	UseSSL:          os.Getenv("USE_SSL") == "true",
+	AccessKeyID:     os.Getenv("BUCKET_ACCESS_KEY_ID"),
+	SecretAccessKey: os.Getenv("BUCKET_SECRET_ACCESS_KEY"),
+}
+
+// Configure replaces the settings read from the environment for clients
+// created afterwards.
+func Configure(s Settings) {
+	settings = s
+}
+
 type S3Client struct {
 	Client *s3.S3
 }
 
 func NewS3Client(checkpoint ...bool) (*S3Client, error) {
-	region := os.Getenv("AWS_REGION")
-	endpoint := os.Getenv("BUCKET_ENDPOINT")
-	useSSL := os.Getenv("USE_SSL") == "true"
+	region := settings.Region
+	endpoint := settings.Endpoint
+	useSSL := settings.UseSSL
 
 	var sess *session.Session
 	var err error
@@ -41,39 +104,36 @@ func NewS3Client(checkpoint ...bool) (*S3Client, error) {
 	}
 
 	if endpoint != "" {
-		fmt.Println("Configuring S3 client for local development")
+		slog.Info("Configuring S3 client for local development", "endpoint", presignedURLEndpoint)
 		sessOpts := session.Options{
 			Config: aws.Config{
 				Region:           aws.String(region),
+				HTTPClient:       httpClient,
 				Endpoint:         aws.String(presignedURLEndpoint),
 				S3ForcePathStyle: aws.Bool(true),
 				DisableSSL:       aws.Bool(!useSSL),
 				Credentials: credentials.NewStaticCredentials(
-					os.Getenv("BUCKET_ACCESS_KEY_ID"),
-					os.Getenv("BUCKET_SECRET_ACCESS_KEY"),
+					settings.AccessKeyID,
+					settings.SecretAccessKey,
 					"",
 				),
 			},
 		}
 		sess, err = session.NewSessionWithOptions(sessOpts)
 	} else {
-		fmt.Println("Configuring S3 client for AWS deployment")
+		slog.Info("Configuring S3 client for AWS deployment", "region", region)
 		sess, err = session.NewSession(&aws.Config{
-			Region: aws.String(region),
+			Region:     aws.String(region),
+			HTTPClient: httpClient,
 		})
-		if err != nil {
-			fmt.Println("Error creating session for S3 client:")
-			fmt.Println(err)
-			return nil, err
-		}
 	}
 
 	if err != nil {
-		fmt.Println("Error creating session for S3 client:")
-		fmt.Println(err)
+		slog.Error("Error creating session for S3 client", "error", err)
 		return nil, err
 	}
 
+	sess.Handlers.Complete.PushBack(observeOperation)
 	return &S3Client{Client: s3.New(sess)}, nil
 }
 
@@ -126,6 +186,24 @@ func (s *S3Client) UploadFile(bucketName, objectName, filePath string) error {
 }
 
 func (s *S3Client) DownloadFile(bucketName, objectName, fileName string) error {
+	return s.DownloadFileWithContext(context.Background(), bucketName, objectName, fileName)
+}
+
+// DownloadFileWithContext is DownloadFile as part of the trace in ctx. Its
+// span includes writing the object to disk.
+func (s *S3Client) DownloadFileWithContext(ctx context.Context, bucketName, objectName, fileName string) (err error) {
+	ctx, span := tracer.Start(ctx, "s3.DownloadFile", trace.WithAttributes(
+		attribute.String("s3.bucket", bucketName),
+		attribute.String("s3.key", objectName),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	// Create a new file in the provided path.
 	file, err := os.Create(fileName)
 	if err != nil {
@@ -134,7 +212,7 @@ func (s *S3Client) DownloadFile(bucketName, objectName, fileName string) error {
 	defer file.Close()
 
 	// Get the object from S3 and write its content to the file.
-	output, err := s.Client.GetObject(&s3.GetObjectInput{
+	output, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(objectName),
 	})
@@ -144,7 +222,8 @@ func (s *S3Client) DownloadFile(bucketName, objectName, fileName string) error {
 	defer output.Body.Close()
 
 	// Copy data from S3 object to the file
-	_, err = io.Copy(file, output.Body)
+	written, err := io.Copy(file, output.Body)
+	span.SetAttributes(attribute.Int64("s3.bytes", written))
 	return err
 }
 
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
 
@@ -66,56 +67,54 @@ func buildTokenMetadata(ioPath, imageCid, tokenName string) (string, error) {
 
 func MintNFT(ioJsonPath, imageCid, tokenName string) {
 	if autotaskWebhook == "" {
-		fmt.Println("AUTOTASK_WEBHOOK must be set")
-		fmt.Println("Please visit https://try.labdao.xyz for instructions")
+		slog.Error("AUTOTASK_WEBHOOK must be set, please visit https://try.labdao.xyz for instructions")
 		os.Exit(1)
 	}
 
 	if recipientWallet == "" {
-		fmt.Println("RECIPIENT_WALLET must be set")
-		fmt.Println("Please visit https://try.labdao.xyz for instructions")
+		slog.Error("RECIPIENT_WALLET must be set, please visit https://try.labdao.xyz for instructions")
 		os.Exit(1)
 	}
 
 	// Build NFT metadata
-	fmt.Println("Preparing NFT metadata...")
+	slog.Info("Preparing NFT metadata")
 	metadata, err := buildTokenMetadata(ioJsonPath, imageCid, tokenName)
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error building NFT metadata", "error", err)
 		os.Exit(1)
 	}
 
 	tempFile, err := ioutil.TempFile("", "metadata-*.json")
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error creating NFT metadata file", "error", err)
 		os.Exit(1)
 	}
 	defer os.Remove(tempFile.Name())
 
 	_, err = tempFile.WriteString(metadata)
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error writing NFT metadata file", "error", err)
 		os.Exit(1)
 	}
 
 	err = tempFile.Close()
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error writing NFT metadata file", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("Uploading NFT metadata to IPFS...")
+	slog.Info("Uploading NFT metadata to IPFS")
 	cid, err := ipfs.PinFile(tempFile.Name())
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error uploading NFT metadata to IPFS", "error", err)
 		os.Exit(1)
 	}
-	fmt.Printf("NFT metadata uploaded to IPFS: ipfs://%s\n", cid)
+	slog.Info("Uploaded NFT metadata to IPFS", "cid", cid)
 
-	fmt.Println("Triggering minting process via Defender Autotask...")
+	slog.Info("Triggering minting process via Defender Autotask")
 	err = triggerMinting(recipientWallet, cid)
 	if err != nil {
-		fmt.Println("Error:", err)
+		slog.Error("Error triggering minting process", "error", err)
 		os.Exit(1)
 	}
 }
@@ -162,14 +161,9 @@ func triggerMinting(recipientAddress, cid string) error {
 	}
 
 	if result.Status == "success" {
-		fmt.Println("\U0001F331\U0001F331\U0001F331\U0001F331\U0001F331") // 5 saplings for open science
-		fmt.Println("Minting process successful.")
-		fmt.Println("Thank you for making science more reproducible, open, and collaborative!")
-		fmt.Println("You can view your ProofOfScience NFT at https://testnets.opensea.io/account.")
-		fmt.Println("\U0001F331\U0001F331\U0001F331\U0001F331\U0001F331") // 5 more saplings for open science
+		slog.Info("Minting process successful, you can view your ProofOfScience NFT at https://testnets.opensea.io/account", "recipient_address", recipientAddress, "cid", cid)
 	} else {
-		fmt.Println("Minting process failed.")
-		fmt.Printf("Response from Autotask: %s\n", string(body))
+		slog.Error("Minting process failed", "response", string(body))
 	}
 
 	return nil